@@ -0,0 +1,1230 @@
+// archive is package that helps create archives in a format that
+// Atlas expects with its various upload endpoints.
+//
+// This package started out as the archive package of atlas-go and has been
+// extended for the needs of the upload CLI.
+package archive
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Archive is the resulting archive. The archive data is generally streamed
+// so the io.ReadCloser can be used to backpressure the archive progress
+// and avoid memory pressure.
+type Archive struct {
+	io.ReadCloser
+
+	Size     int64
+	Metadata map[string]string
+
+	// Format is the format of the archive, such as FormatTarGzip. For an
+	// archive that is passed through as-is, it is the detected format.
+	Format string
+
+	// Entries and UncompressedSize are the number of entries in an archive
+	// that is passed through as-is and the total size of their contents.
+	// They are only known if the archive was validated, and are zero
+	// otherwise. See ArchiveOpts.NoValidation.
+	Entries          int
+	UncompressedSize int64
+
+	// Files is the number of files (not counting directories) in the
+	// archive. It is zero if the path was an archive that is passed
+	// through as-is.
+	Files int
+
+	// ContentHash is the hex-encoded SHA-256 hash of the names, types and
+	// contents of the entries in the archive. Unlike a checksum of the
+	// archive itself, it doesn't depend on timestamps, owners or the
+	// compression, so it only changes when the files do. For an archive
+	// that is passed through as-is, it is the checksum of the archive.
+	ContentHash string
+
+	// SHA256 and MD5 are the hex-encoded checksums of the archive data
+	// itself. They are computed while the archive is written, or read for
+	// an archive that is passed through as-is.
+	SHA256 string
+	MD5    string
+}
+
+// Seek implements io.Seeker so that the archive can be rewound and read
+// again, for example to retry an upload. Every archive that is created by
+// CreateArchive can be rewound.
+func (a *Archive) Seek(offset int64, whence int) (int64, error) {
+	s, ok := a.ReadCloser.(io.Seeker)
+	if !ok {
+		return 0, fmt.Errorf("archive can't be rewound")
+	}
+
+	return s.Seek(offset, whence)
+}
+
+// ArchiveOpts are the options for defining how the archive will be built.
+type ArchiveOpts struct {
+	// Exclude and Include are filters of files to include/exclude in
+	// the archive when creating it from a directory. These filters are
+	// glob patterns that are matched against the slash-separated path
+	// relative to the packaging directory, where "**" matches any number
+	// of directories. Everything inside a directory that matches an
+	// Include pattern is included.
+	Exclude []string
+	Include []string
+
+	// LegacyGlobs, if true, matches Exclude and Include the way older
+	// versions did: excludes with filepath.Match and includes with
+	// filepath.Glob, neither of which supports "**".
+	LegacyGlobs bool
+
+	// Extra is a mapping of extra files to include within the archive. The
+	// key should be the path within the archive and the value should be
+	// an absolute path to the file to put into the archive. These extra
+	// files will override any other files in the archive.
+	Extra map[string]string
+
+	// VCS, if true, will detect and use a VCS system to determine what
+	// files to include the archive.
+	VCS bool
+
+	// NoIgnoreFile, if true, will not read the IgnoreFile in the root of the
+	// directory to archive. Otherwise the paths it lists are left out of
+	// the archive, using the same syntax as a .gitignore file.
+	NoIgnoreFile bool
+
+	// NestedIgnoreFiles, if true, will also read the IgnoreFile in every
+	// directory below the root. Its patterns are relative to the directory
+	// it is in and take precedence over the ones higher up.
+	NestedIgnoreFiles bool
+
+	// Reproducible, if true, creates the same archive byte for byte for the
+	// same files, no matter when, where or by whom they were checked out.
+	// The timestamps, owners and permissions of the entries are normalized.
+	Reproducible bool
+
+	// ModTime is the modification time of every entry of a reproducible
+	// archive. If it is zero, the time from the SOURCE_DATE_EPOCH
+	// environment variable is used, or else the Unix epoch.
+	ModTime time.Time
+
+	// NoValidation, if true, passes a path that is an archive through
+	// without validating it. Otherwise it is read to the end first, and
+	// rejected if it doesn't decompress, doesn't parse, has no entries or
+	// has entries with absolute paths or paths that go up with "..".
+	NoValidation bool
+
+	// Format is the format of the archive of a directory, one of Formats.
+	// If it is empty, the archive is a gzipped tar. A path that is a tar,
+	// compressed in any of the formats or not, is passed through as-is no
+	// matter the format, and a path that is a zip only if it is FormatZip.
+	Format string
+
+	// CompressionLevel is the compression level of the archive of a
+	// directory, one of the levels of compress/gzip: from BestSpeed to
+	// BestCompression, or NoCompression to store the files without
+	// compressing them. If it is nil, DefaultCompression is used. A gzipped
+	// tar is compressed on all cores. The zstd levels are the ones with the
+	// same number, NoCompression can't be used with FormatTarZstd, and
+	// FormatTarXz only has the default level.
+	CompressionLevel *int
+
+	// TempDir is the directory that CreateArchive buffers the archive in.
+	// If it is empty, the default directory for temporary files is used.
+	// A warning is logged before the archive is written if the files don't
+	// fit in its free space uncompressed, and if the archive doesn't fit in
+	// it, the error says so.
+	TempDir string
+}
+
+// compressionLevel returns the CompressionLevel, which is
+// DefaultCompression if it isn't set.
+func (o *ArchiveOpts) compressionLevel() int {
+	if o.CompressionLevel == nil {
+		return DefaultCompression
+	}
+
+	return *o.CompressionLevel
+}
+
+// IsSet says whether any options were set.
+func (o *ArchiveOpts) IsSet() bool {
+	return len(o.Exclude) > 0 || len(o.Include) > 0 || o.VCS
+}
+
+// Constants related to setting special values for Extra in ArchiveOpts.
+const (
+	// ExtraEntryDir just creates the Extra key as a directory entry.
+	ExtraEntryDir = ""
+)
+
+// CreateArchive takes the given path and ArchiveOpts and archives it.
+//
+// The archive will be fully completed and put into a temporary file.
+// This must be done to retrieve the content length of the archive which
+// is needed for almost all operations involving archives with Atlas. Because
+// of this, sufficient disk space will be required to buffer the archive; a
+// warning is logged if it may not fit, see ArchiveOpts.TempDir. Use
+// NewStream to write the archive without buffering it.
+func CreateArchive(path string, opts *ArchiveOpts) (*Archive, error) {
+	return CreateArchiveContext(context.Background(), path, opts)
+}
+
+// CreateArchiveContext is like CreateArchive, but stops archiving when the
+// context is done. In that case the temporary file is removed and the error
+// of the context is returned.
+func CreateArchiveContext(ctx context.Context, path string, opts *ArchiveOpts) (*Archive, error) {
+	log.Printf("[INFO] creating archive from %s", path)
+
+	path, fi, err := resolvePath(path, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if fi.IsDir() {
+		return archiveDir(ctx, path, opts)
+	} else {
+		return archiveFile(ctx, path, opts)
+	}
+}
+
+// resolvePath dereferences any symlinks and returns the real path and info
+// for the path that is archived. It also verifies that the options can be
+// used with that path.
+func resolvePath(path string, opts *ArchiveOpts) (string, os.FileInfo, error) {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return "", nil, err
+	}
+	if fi.Mode()&os.ModeSymlink != 0 {
+		path, fi, err = readLinkFull(path, fi)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	// Windows
+	path = filepath.ToSlash(path)
+
+	// Direct file paths cannot have archive options
+	if !fi.IsDir() && opts.IsSet() {
+		return "", nil, fmt.Errorf(
+			"options such as exclude, include, and VCS can't be set when " +
+				"the path is a file.")
+	}
+
+	return path, fi, nil
+}
+
+func archiveFile(ctx context.Context, path string, opts *ArchiveOpts) (*Archive, error) {
+	format, err := passthroughFormat(path, opts.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	if format != "" {
+		// This is an archive already, let it through. Make sure it can be
+		// extracted, read it once for the checksums, then reopen it for the
+		// data.
+		var v *validation
+		if !opts.NoValidation {
+			v, err = validateArchive(ctx, path, format)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		a, err := copyArchive(ctx, path, format, ioutil.Discard)
+		if err != nil {
+			return nil, err
+		}
+		a.setValidation(v)
+
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		a.ReadCloser = f
+
+		return a, nil
+	}
+
+	// We have a single file that is not an archive. Archive it.
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return archiveDir(ctx, filepath.Dir(path), fileOpts(path, opts))
+}
+
+// fileOpts returns the options for archiving the single file at the given
+// absolute path, which is done by archiving its directory with only this one
+// file included.
+func fileOpts(path string, opts *ArchiveOpts) *ArchiveOpts {
+	return &ArchiveOpts{
+		Include:          []string{quoteGlob(filepath.Base(path))},
+		NoIgnoreFile:     true,
+		Reproducible:     opts.Reproducible,
+		ModTime:          opts.ModTime,
+		Format:           opts.Format,
+		CompressionLevel: opts.CompressionLevel,
+		TempDir:          opts.TempDir,
+	}
+}
+
+// copyArchive copies the archive in the given format at the given path,
+// which is passed through as-is, to w and returns its description without
+// any data. The content hash of an archive that we let through is its
+// checksum, since we don't look inside.
+func copyArchive(ctx context.Context, path, format string, w io.Writer) (*Archive, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sha, md := sha256.New(), md5.New()
+	size, err := io.Copy(io.MultiWriter(w, sha, md), &contextReader{ctx: ctx, r: f})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Archive{
+		Size:        size,
+		Format:      format,
+		ContentHash: hex.EncodeToString(sha.Sum(nil)),
+		SHA256:      hex.EncodeToString(sha.Sum(nil)),
+		MD5:         hex.EncodeToString(md.Sum(nil)),
+	}, nil
+}
+
+// setValidation sets the results of the validation of an archive that is
+// passed through as-is, if it was validated.
+func (a *Archive) setValidation(v *validation) {
+	if v != nil {
+		a.Entries, a.UncompressedSize = v.entries, v.size
+	}
+}
+
+func archiveDir(ctx context.Context, root string, opts *ArchiveOpts) (*Archive, error) {
+	d, err := newDirArchiver(root, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create the temporary file that we'll send the archive data to.
+	archiveF, err := ioutil.TempFile(opts.TempDir, "atlas-archive")
+	if err != nil {
+		return nil, err
+	}
+
+	// Create the wrapper for the result which will automatically
+	// remove the temporary file on close.
+	archiveWrapper := &readCloseRemover{F: archiveF}
+
+	// Warn before writing if the archive may not fit, and say so if it
+	// doesn't
+	dir := filepath.Dir(archiveF.Name())
+	d.checkFreeSpace(dir)
+
+	a, err := d.write(ctx, &spaceWriter{w: archiveF, dir: dir})
+	if err != nil {
+		archiveWrapper.Close()
+		return nil, err
+	}
+
+	// Seek to the beginning
+	if _, err := archiveWrapper.F.Seek(0, 0); err != nil {
+		archiveWrapper.Close()
+		return nil, err
+	}
+
+	a.ReadCloser = archiveWrapper
+	return a, nil
+}
+
+// dirArchiver writes the archive of a directory. Everything that doesn't
+// change while archiving, such as the files that are tracked by the VCS, is
+// looked up once when it is created, so that the archive can be written any
+// number of times.
+type dirArchiver struct {
+	root       string
+	opts       *ArchiveOpts
+	vcsInclude []string
+	metadata   map[string]string
+	ignore     *ignoreMatcher
+	normalize  func(*tar.Header)
+	format     string
+}
+
+func newDirArchiver(root string, opts *ArchiveOpts) (*dirArchiver, error) {
+	d := &dirArchiver{opts: opts}
+	if opts.VCS {
+		var err error
+
+		if err = vcsPreflight(root); err != nil {
+			return nil, err
+		}
+
+		d.vcsInclude, err = vcsFiles(root)
+		if err != nil {
+			return nil, err
+		}
+
+		d.metadata, err = vcsMetadata(root)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Make sure the root path is absolute
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	d.root = root
+
+	d.ignore, err = ignoreFiles(root, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check the patterns now rather than on every walk
+	if _, err := newWalker(opts, d.vcsInclude, d.ignore); err != nil {
+		return nil, err
+	}
+
+	d.normalize, err = headerFunc(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	d.format, err = checkFormat(opts.Format, opts.compressionLevel())
+	if err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// walk calls visit for every path that is considered for the archive, see
+// visitFunc.
+func (d *dirArchiver) walk(visit visitFunc) error {
+	walker, err := newWalker(d.opts, d.vcsInclude, d.ignore)
+	if err != nil {
+		return err
+	}
+
+	return walker.walk(d.root, visit)
+}
+
+// write writes the archive to w and returns its description, without any
+// data. The same files always produce the same bytes.
+func (d *dirArchiver) write(ctx context.Context, w io.Writer) (*Archive, error) {
+	// Checksum and count the archive data on its way out
+	sha, md := sha256.New(), md5.New()
+	size := &countWriter{}
+
+	// Buffer the writer so that we can push as much data to disk at
+	// a time as possible. 4M should be good.
+	bufW := bufio.NewWriterSize(io.MultiWriter(w, sha, md, size), 4096*1024)
+
+	// Archive and compress the file contents
+	entryW, err := newEntryWriter(bufW, d.format, d.opts.compressionLevel())
+	if err != nil {
+		return nil, err
+	}
+
+	// The compressor may run goroutines until it is closed, so close it on
+	// every path out of here, even if the walk panics. It is closed below
+	// as well to get its error; closing it again is a no-op.
+	defer entryW.Close()
+
+	// Walk the path and do the normal files, then the extra files
+	v := newTarVisitor(ctx, entryW, d.normalize)
+	werr := d.walk(v.visit)
+
+	// Attempt to close all the things. If we get an error on the way
+	// and we haven't had an error yet, then record that as the critical
+	// error. But we still try to close everything.
+
+	// Close the archive and the compressor
+	if err := entryW.Close(); err != nil && werr == nil {
+		werr = err
+	}
+
+	// Flush the buffer
+	if err := bufW.Flush(); err != nil && werr == nil {
+		werr = err
+	}
+
+	// If we were stopped, the errors above are only a symptom of that, so
+	// report why we were stopped instead.
+	if ctx.Err() != nil {
+		werr = ctx.Err()
+	}
+
+	if werr != nil {
+		return nil, werr
+	}
+
+	return &Archive{
+		Size:        size.n,
+		Metadata:    d.metadata,
+		Format:      d.format,
+		Files:       v.files,
+		ContentHash: v.contentHash(),
+		SHA256:      hex.EncodeToString(sha.Sum(nil)),
+		MD5:         hex.EncodeToString(md.Sum(nil)),
+	}, nil
+}
+
+// estimateSize returns how large the archive can get: the size of the files
+// plus a header and padding for every entry. Compression usually makes it a
+// lot smaller, but files that are already compressed don't shrink.
+func (d *dirArchiver) estimateSize() (int64, error) {
+	// Two empty blocks end the archive
+	size := int64(2 * 512)
+	err := d.walk(func(entry, path string, info os.FileInfo, reason string) error {
+		if reason != "" {
+			return nil
+		}
+
+		size += 2 * 512
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+
+	return size, err
+}
+
+// checkFreeSpace warns if the archive may not fit in the free space of the
+// directory that it is buffered in. Since the size is only an estimate, the
+// archive is written anyway, and spaceWriter reports it if it really doesn't
+// fit.
+func (d *dirArchiver) checkFreeSpace(dir string) {
+	free, err := freeSpace(dir)
+	if err != nil {
+		log.Printf("[DEBUG] not checking the free space in %s: %s", dir, err)
+		return
+	}
+
+	size, err := d.estimateSize()
+	if err != nil {
+		// Writing the archive fails the same way
+		log.Printf("[DEBUG] not checking the free space in %s: %s", dir, err)
+		return
+	}
+
+	log.Printf("[DEBUG] the archive may take up to %d bytes, %d bytes are free in %s",
+		size, free, dir)
+	if size > free {
+		log.Printf("[WARN] the archive may take up to %d bytes, but only %d bytes "+
+			"are free in %s; it only fits if the files compress well", size, free, dir)
+	}
+}
+
+// freeSpace returns the number of bytes that are free in the file system of
+// the given directory. It is a variable so that tests can stub it.
+var freeSpace = dirFreeSpace
+
+// spaceWriter writes the archive to the temporary file that it is buffered
+// in. The size of the archive isn't known until it was written, so instead of
+// guessing up front whether it fits, a write that fails because the file
+// system is full says so.
+type spaceWriter struct {
+	w   io.Writer
+	dir string
+	n   int64
+}
+
+func (s *spaceWriter) Write(p []byte) (int, error) {
+	n, err := s.w.Write(p)
+	s.n += int64(n)
+	if err == nil {
+		return n, nil
+	}
+
+	if free, ferr := freeSpace(s.dir); ferr == nil && free < int64(len(p)-n) {
+		err = fmt.Errorf(
+			"not enough free space in %s to buffer the archive: %d bytes were "+
+				"written and only %d bytes are free: %s", s.dir, s.n, free, err)
+	}
+
+	return n, err
+}
+
+// visitFunc is called for every path that is considered for the archive.
+// The entry is the path within the archive and path is the real path on disk
+// (which is the target if the entry is a symlink). If the entry is excluded
+// from the archive, reason says why; otherwise reason is empty.
+//
+// For excluded directories, visitFunc is called only once for the directory
+// itself since none of its children are considered.
+type visitFunc func(entry, path string, info os.FileInfo, reason string) error
+
+// Reasons that are given to a visitFunc for excluded entries.
+const (
+	reasonVCS     = "not tracked by the VCS"
+	reasonInclude = "not matched by any include pattern"
+)
+
+// tarVisitor copies every included entry that it visits into an archive,
+// until the context is done.
+type tarVisitor struct {
+	ctx  context.Context
+	tarW entryWriter
+
+	// normalize, if not nil, is called with the header of every entry
+	// before it is written. See headerFunc.
+	normalize func(*tar.Header)
+
+	// content is the hash of the content of the entries, see
+	// Archive.ContentHash, and files is the number of files that were
+	// copied.
+	content hash.Hash
+	files   int
+}
+
+func newTarVisitor(ctx context.Context, tarW entryWriter, normalize func(*tar.Header)) *tarVisitor {
+	return &tarVisitor{
+		ctx:       ctx,
+		tarW:      tarW,
+		normalize: normalize,
+		content:   sha256.New(),
+	}
+}
+
+func (v *tarVisitor) visit(entry, path string, info os.FileInfo, reason string) error {
+	if err := v.ctx.Err(); err != nil {
+		return err
+	}
+
+	if reason != "" {
+		return nil
+	}
+
+	if !info.IsDir() {
+		v.files++
+	}
+
+	return v.copyConcreteEntry(entry, path, info)
+}
+
+// contentHash returns the hex-encoded content hash of the entries that were
+// copied.
+func (v *tarVisitor) contentHash() string {
+	return hex.EncodeToString(v.content.Sum(nil))
+}
+
+// ignoreFiles returns the matcher for the ignore files in root, or nil if
+// they are disabled by the options.
+func ignoreFiles(root string, opts *ArchiveOpts) (*ignoreMatcher, error) {
+	if opts.NoIgnoreFile {
+		return nil, nil
+	}
+
+	ignore, err := newIgnoreMatcher(root, opts.NestedIgnoreFiles)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %s", IgnoreFile, err)
+	}
+
+	return ignore, nil
+}
+
+// walker holds the rules for which entries are archived and the state that
+// is shared by the walks over the path to archive, including the walks over
+// the targets of symlinked directories.
+type walker struct {
+	opts       *ArchiveOpts
+	vcsInclude []string
+	ignore     *ignoreMatcher
+	visit      visitFunc
+
+	// include and exclude are the compiled -include and -exclude patterns.
+	// They are not used with opts.LegacyGlobs.
+	include []*globPattern
+	exclude []*globPattern
+
+	// pending are the directories that are only archived if an entry below
+	// them is, and the excluded entries that were visited since, in the
+	// order they were walked. See visitEntry.
+	pending []pendingEntry
+}
+
+// pendingEntry is a visit that is held back until it is known whether the
+// pending directory it is in is archived.
+type pendingEntry struct {
+	entry, path string
+	info        os.FileInfo
+	reason      string
+
+	// dir is true for a pending directory itself.
+	dir bool
+}
+
+// newWalker returns a walker for the given options, which may be nil to
+// archive everything. It fails if a pattern in the options is invalid.
+func newWalker(
+	opts *ArchiveOpts, vcsInclude []string, ignore *ignoreMatcher) (*walker, error) {
+	w := &walker{
+		opts:       opts,
+		vcsInclude: vcsInclude,
+		ignore:     ignore,
+	}
+
+	if opts != nil && !opts.LegacyGlobs {
+		var err error
+		if w.include, err = compileGlobs(opts.Include); err != nil {
+			return nil, fmt.Errorf("error checking include glob: %s", err)
+		}
+		if w.exclude, err = compileGlobs(opts.Exclude); err != nil {
+			return nil, fmt.Errorf("error checking exclude glob: %s", err)
+		}
+	}
+
+	return w, nil
+}
+
+// walk walks the root and then the extra files from the options, calling
+// visit for every entry.
+func (w *walker) walk(root string, visit visitFunc) error {
+	w.visit = visit
+	if err := filepath.Walk(root, walkFn(root, "", w)); err != nil {
+		return err
+	}
+	if err := w.leavePending(""); err != nil {
+		return err
+	}
+
+	if w.opts == nil {
+		return nil
+	}
+	return walkExtras(w.opts.Extra, visit)
+}
+
+// includeReason checks the entry against the include patterns. It returns
+// reasonInclude if the entry is excluded, and pending is true for a
+// directory that is only walked because it may contain an entry that is
+// included.
+//
+// An entry is included if it matches a pattern or if it is inside a
+// directory that matches a pattern.
+func (w *walker) includeReason(entry string, dir bool) (reason string, pending bool) {
+	if len(w.include) == 0 {
+		return "", false
+	}
+
+	for _, p := range w.include {
+		if p.match(entry) {
+			return "", false
+		}
+	}
+
+	for _, parent := range parentDirs(entry) {
+		for _, p := range w.include {
+			if p.match(parent) {
+				return "", false
+			}
+		}
+	}
+
+	if dir {
+		for _, p := range w.include {
+			if p.matchParent(entry) {
+				return "", true
+			}
+		}
+	}
+
+	return reasonInclude, false
+}
+
+// excludeReason returns the reason the entry is excluded by the exclude
+// patterns, if it is.
+func (w *walker) excludeReason(entry string) (string, error) {
+	if w.opts == nil {
+		return "", nil
+	}
+
+	for i, exclude := range w.opts.Exclude {
+		var match bool
+		if w.opts.LegacyGlobs {
+			var err error
+			if match, err = filepath.Match(exclude, entry); err != nil {
+				return "", err
+			}
+		} else {
+			match = w.exclude[i].match(entry)
+		}
+
+		if match {
+			return fmt.Sprintf("matched exclude pattern %q", exclude), nil
+		}
+	}
+
+	return "", nil
+}
+
+// visitEntry visits the entry. Entries below a pending directory are held
+// back until an entry below it is included, at which point the directory
+// and everything that was held back are visited in order. If the walk
+// leaves the directory without including anything, the directory is
+// visited as excluded instead.
+func (w *walker) visitEntry(entry, path string, info os.FileInfo, reason string) error {
+	if err := w.leavePending(entry); err != nil {
+		return err
+	}
+
+	if reason != "" && len(w.pending) > 0 {
+		w.pending = append(w.pending, pendingEntry{
+			entry: entry, path: path, info: info, reason: reason})
+		return nil
+	}
+
+	if reason == "" {
+		for _, p := range w.pending {
+			if err := w.visit(p.entry, p.path, p.info, p.reason); err != nil {
+				return err
+			}
+		}
+		w.pending = nil
+	}
+
+	return w.visit(entry, path, info, reason)
+}
+
+// addPending adds a directory that is only archived if an entry below it is.
+func (w *walker) addPending(entry, path string, info os.FileInfo) error {
+	if err := w.leavePending(entry); err != nil {
+		return err
+	}
+
+	w.pending = append(w.pending, pendingEntry{
+		entry: entry, path: path, info: info, dir: true})
+	return nil
+}
+
+// leavePending visits the pending directories that the entry is not in as
+// excluded, since the walk is depth-first and nothing in them was included.
+// Use an empty entry at the end of the walk to leave all of them.
+func (w *walker) leavePending(entry string) error {
+	for {
+		i := len(w.pending) - 1
+		for i >= 0 && !w.pending[i].dir {
+			i--
+		}
+		if i < 0 || strings.HasPrefix(entry, w.pending[i].entry+"/") {
+			return nil
+		}
+
+		// Drop the directory and what was held back below it, and visit
+		// the directory as excluded instead
+		dir := w.pending[i]
+		w.pending = w.pending[:i]
+		dir.reason = reasonInclude
+		dir.dir = false
+		if len(w.pending) > 0 {
+			w.pending = append(w.pending, dir)
+			continue
+		}
+
+		if err := w.visit(dir.entry, dir.path, dir.info, dir.reason); err != nil {
+			return err
+		}
+	}
+}
+
+// legacyIncludeMap returns the entries that are included by the include
+// patterns with opts.LegacyGlobs: the paths that filepath.Glob returns for
+// every pattern joined to the root, and their parent directories.
+func legacyIncludeMap(root string, patterns []string) (map[string]struct{}, error) {
+	includeMap := make(map[string]struct{})
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(filepath.Join(root, pattern))
+		if err != nil {
+			return nil, fmt.Errorf(
+				"error checking include glob '%s': %s",
+				pattern, err)
+		}
+
+		for _, path := range matches {
+			// Windows
+			path = filepath.ToSlash(path)
+			subpath, err := filepath.Rel(root, path)
+			subpath = filepath.ToSlash(subpath)
+
+			if err != nil {
+				return nil, err
+			}
+
+			for {
+				includeMap[subpath] = struct{}{}
+				subpath = filepath.Dir(subpath)
+				if subpath == "." {
+					break
+				}
+			}
+		}
+	}
+
+	return includeMap, nil
+}
+
+func walkFn(root string, prefix string, w *walker) filepath.WalkFunc {
+	errFunc := func(err error) filepath.WalkFunc {
+		return func(string, os.FileInfo, error) error {
+			return err
+		}
+	}
+
+	// Windows
+	root = filepath.ToSlash(root)
+
+	var includeMap map[string]struct{}
+
+	// If we have an include pattern set with the legacy globs, then setup
+	// the lookup table to determine what we want to include.
+	if w.opts != nil && w.opts.LegacyGlobs && len(w.opts.Include) > 0 {
+		var err error
+		includeMap, err = legacyIncludeMap(root, w.opts.Include)
+		if err != nil {
+			return errFunc(err)
+		}
+	}
+
+	return func(path string, info os.FileInfo, err error) error {
+		path = filepath.ToSlash(path)
+
+		if err != nil {
+			return err
+		}
+
+		// Get the relative path from the path since it contains the root
+		// plus the path.
+		subpath, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if subpath == "." {
+			return nil
+		}
+		if prefix != "" {
+			subpath = filepath.Join(prefix, subpath)
+		}
+		// Windows
+		subpath = filepath.ToSlash(subpath)
+
+		// If we have a list of VCS files, check that first
+		reason := ""
+		if len(w.vcsInclude) > 0 {
+			reason = reasonVCS
+			for _, f := range w.vcsInclude {
+				if f == subpath {
+					reason = ""
+					break
+				}
+
+				if info.IsDir() && strings.HasPrefix(f, subpath+"/") {
+					reason = ""
+					break
+				}
+			}
+		}
+
+		// If include is present, we only include what is listed
+		pending := false
+		if len(includeMap) > 0 {
+			if _, ok := includeMap[subpath]; !ok {
+				reason = reasonInclude
+			}
+		} else if r, p := w.includeReason(subpath, info.IsDir()); r != "" {
+			reason = r
+		} else {
+			pending = p
+		}
+
+		// Leave out what the ignore files list, unless it is already left
+		// out for another reason
+		if reason == "" {
+			if rule := w.ignore.match(subpath, info.IsDir()); rule != nil {
+				reason = fmt.Sprintf("matched ignore pattern %q (%s)",
+					rule.pattern, rule.source)
+			}
+		}
+
+		// If exclude, it is one last gate to excluding files
+		if r, err := w.excludeReason(subpath); err != nil {
+			return err
+		} else if r != "" {
+			reason = r
+		}
+
+		// If we have to skip this file, then skip it, properly skipping
+		// children if we're a directory.
+		if reason != "" {
+			if err := w.visitEntry(subpath, path, info, reason); err != nil {
+				return err
+			}
+
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		// If this is a symlink, then we need to get the symlink target
+		// rather than the symlink itself.
+		symlink := info.Mode()&os.ModeSymlink != 0
+		target := path
+		if symlink {
+			target, info, err = readLinkFull(path, info)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Visit the concrete entry for this path. This will either be the
+		// file itself or just a directory entry.
+		if pending {
+			err = w.addPending(subpath, target, info)
+		} else {
+			err = w.visitEntry(subpath, target, info, "")
+		}
+		if err != nil {
+			return err
+		}
+
+		if !info.IsDir() {
+			return nil
+		}
+
+		// The ignore file in this directory applies to its children
+		if err := w.ignore.loadDir(subpath, target); err != nil {
+			return err
+		}
+
+		if symlink {
+			return filepath.Walk(target, walkFn(target, subpath, w))
+		}
+
+		return nil
+	}
+}
+
+func (v *tarVisitor) copyConcreteEntry(entry string, path string, info os.FileInfo) error {
+	// Windows
+	path = filepath.ToSlash(path)
+
+	// Build the file header for the tar entry
+	header, err := tar.FileInfoHeader(info, path)
+	if err != nil {
+		return fmt.Errorf(
+			"failed creating archive header: %s", path)
+	}
+
+	// Modify the header to properly be the full entry name
+	header.Name = entry
+	if info.IsDir() {
+		header.Name += "/"
+	}
+
+	// Only what ends up in the files is part of the content hash, not
+	// when, where or by whom they were checked out
+	kind := "f"
+	if info.IsDir() {
+		kind = "d"
+	} else if info.Mode()&0111 != 0 {
+		kind = "x"
+	}
+	fmt.Fprintf(v.content, "%s %d %s\x00", kind, header.Size, header.Name)
+
+	if v.normalize != nil {
+		v.normalize(header)
+	}
+
+	// Write the header first to the archive.
+	if err := v.tarW.WriteHeader(header); err != nil {
+		return fmt.Errorf(
+			"failed writing archive header: %s", path)
+	}
+
+	// If it is a directory, then we're done (no body to write)
+	if info.IsDir() {
+		return nil
+	}
+
+	// Open the real file to write the data
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf(
+			"failed opening file '%s' to write compressed archive.", path)
+	}
+	defer f.Close()
+
+	w := io.MultiWriter(v.tarW, v.content)
+	if _, err = io.Copy(w, &contextReader{ctx: v.ctx, r: f}); err != nil {
+		return fmt.Errorf(
+			"failed copying file to archive: %s", path)
+	}
+
+	return nil
+}
+
+func walkExtras(extra map[string]string, visit visitFunc) error {
+	var tmpDir string
+	defer func() {
+		if tmpDir != "" {
+			os.RemoveAll(tmpDir)
+		}
+	}()
+
+	// Add the extra files in a deterministic order
+	entries := make([]string, 0, len(extra))
+	for entry := range extra {
+		entries = append(entries, entry)
+	}
+	sort.Strings(entries)
+
+	for _, entry := range entries {
+		path := extra[entry]
+
+		// If the path is empty, then we set it to a generic empty directory
+		if path == "" {
+			// If tmpDir is still empty, then we create an empty dir
+			if tmpDir == "" {
+				td, err := ioutil.TempDir("", "archive")
+				if err != nil {
+					return err
+				}
+
+				tmpDir = td
+			}
+
+			path = tmpDir
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+
+		// No matter what, visit the entry. If this is a directory,
+		// it'll just be the directory header.
+		if err := visit(entry, path, info, ""); err != nil {
+			return err
+		}
+
+		// If this is a directory, then we walk the internal contents
+		// and visit those as well.
+		if info.IsDir() {
+			err := filepath.Walk(path, walkFn(
+				path, entry, &walker{visit: visit}))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func readLinkFull(path string, info os.FileInfo) (string, os.FileInfo, error) {
+	// Read the symlink continously until we reach a concrete file.
+	target := path
+	tries := 0
+	for info.Mode()&os.ModeSymlink != 0 {
+		var err error
+		target, err = os.Readlink(target)
+		if err != nil {
+			return "", nil, err
+		}
+		if !filepath.IsAbs(target) {
+			target, err = filepath.Abs(target)
+			if err != nil {
+				return "", nil, err
+			}
+		}
+		info, err = os.Lstat(target)
+		if err != nil {
+			return "", nil, err
+		}
+
+		tries++
+		if tries > 100 {
+			return "", nil, fmt.Errorf(
+				"Symlink for %s is too deep, over 100 levels deep",
+				path)
+		}
+	}
+
+	return target, info, nil
+}
+
+// contextReader is an io.Reader that stops reading once the context is done,
+// so that copying a large file can be interrupted.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return r.r.Read(p)
+}
+
+// countWriter is an io.Writer that counts the bytes that are written to it.
+type countWriter struct {
+	n int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// readCloseRemover is an io.ReadCloser implementation that will remove
+// the file on Close(). We use this to clean up our temporary file for
+// the archive.
+type readCloseRemover struct {
+	F *os.File
+}
+
+func (r *readCloseRemover) Read(p []byte) (int, error) {
+	return r.F.Read(p)
+}
+
+func (r *readCloseRemover) Seek(offset int64, whence int) (int64, error) {
+	return r.F.Seek(offset, whence)
+}
+
+func (r *readCloseRemover) Close() error {
+	// First close the file
+	err := r.F.Close()
+
+	// Next make sure to remove it, or at least try, regardless of error
+	// above.
+	os.Remove(r.F.Name())
+
+	return err
+}
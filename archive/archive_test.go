@@ -0,0 +1,416 @@
+package archive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+const fixturesDir = "./test-fixtures"
+
+func testFixture(n string) string {
+	return filepath.Join(fixturesDir, n)
+}
+
+func TestCreateArchive(t *testing.T) {
+	cases := []struct {
+		Name    string
+		Opts    *ArchiveOpts
+		Files   int
+		Entries []string
+	}{
+		{
+			"all",
+			&ArchiveOpts{},
+			5,
+			[]string{
+				".env",
+				"README.md",
+				"node_modules/",
+				"node_modules/dep/",
+				"node_modules/dep/index.js",
+				"subdir/",
+				"subdir/app.log",
+				"subdir/hello.txt",
+			},
+		},
+		{
+			"exclude",
+			&ArchiveOpts{Exclude: []string{".env", "node_modules", "subdir/*.log"}},
+			2,
+			[]string{
+				"README.md",
+				"subdir/",
+				"subdir/hello.txt",
+			},
+		},
+		{
+			"include",
+			&ArchiveOpts{Include: []string{"subdir/hello.txt"}},
+			1,
+			[]string{
+				"subdir/",
+				"subdir/hello.txt",
+			},
+		},
+		{
+			"include directory",
+			&ArchiveOpts{Include: []string{"subdir"}},
+			2,
+			[]string{
+				"subdir/",
+				"subdir/app.log",
+				"subdir/hello.txt",
+			},
+		},
+		{
+			"include doublestar",
+			&ArchiveOpts{Include: []string{"**/*.js", "**/*.md"}},
+			2,
+			[]string{
+				"README.md",
+				"node_modules/",
+				"node_modules/dep/",
+				"node_modules/dep/index.js",
+			},
+		},
+		{
+			"exclude doublestar",
+			&ArchiveOpts{Exclude: []string{"**/*.log", "node_modules/**"}},
+			3,
+			[]string{
+				".env",
+				"README.md",
+				"node_modules/",
+				"subdir/",
+				"subdir/hello.txt",
+			},
+		},
+		{
+			"legacy globs",
+			&ArchiveOpts{
+				Include:     []string{"subdir", "**/*.js"},
+				Exclude:     []string{"**/*.log"},
+				LegacyGlobs: true,
+			},
+			0,
+			[]string{
+				"subdir/",
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		r, err := CreateArchive(testFixture("archive-subdir"), tc.Opts)
+		if err != nil {
+			t.Fatalf("%s: err: %s", tc.Name, err)
+		}
+
+		if r.Files != tc.Files {
+			t.Fatalf("%s: expected %d files, got %d", tc.Name, tc.Files, r.Files)
+		}
+
+		entries := testArchiveEntries(t, r)
+		r.Close()
+
+		if !reflect.DeepEqual(entries, tc.Entries) {
+			t.Fatalf("%s: expected %#v to be %#v", tc.Name, entries, tc.Entries)
+		}
+	}
+}
+
+func TestCreateArchive_ignoreFile(t *testing.T) {
+	cases := []struct {
+		Name    string
+		Opts    *ArchiveOpts
+		Entries []string
+	}{
+		{
+			"root",
+			&ArchiveOpts{},
+			[]string{
+				".atlasignore",
+				"app.js",
+				"docs/",
+				"docs/a/",
+				"docs/a/b/",
+				"docs/readme.md",
+				"keep.log",
+				"src/",
+				"src/build/",
+				"src/build/app.js",
+				"sub/",
+				"sub/.atlasignore",
+				"sub/ok.txt",
+				"sub/secret.txt",
+			},
+		},
+		{
+			"nested",
+			&ArchiveOpts{NestedIgnoreFiles: true},
+			[]string{
+				".atlasignore",
+				"app.js",
+				"docs/",
+				"docs/a/",
+				"docs/a/b/",
+				"docs/readme.md",
+				"keep.log",
+				"src/",
+				"src/build/",
+				"src/build/app.js",
+				"sub/",
+				"sub/.atlasignore",
+				"sub/app.log",
+				"sub/ok.txt",
+			},
+		},
+		{
+			"disabled",
+			&ArchiveOpts{NoIgnoreFile: true, Exclude: []string{"docs", "src", "sub"}},
+			[]string{
+				".atlasignore",
+				".env",
+				"app.js",
+				"build/",
+				"build/out.bin",
+				"debug.log",
+				"keep.log",
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		r, err := CreateArchive(testFixture("archive-ignore"), tc.Opts)
+		if err != nil {
+			t.Fatalf("%s: err: %s", tc.Name, err)
+		}
+
+		entries := testArchiveEntries(t, r)
+		r.Close()
+
+		if !reflect.DeepEqual(entries, tc.Entries) {
+			t.Fatalf("%s: expected %#v to be %#v", tc.Name, entries, tc.Entries)
+		}
+	}
+}
+
+func TestCreateArchive_removesTempFile(t *testing.T) {
+	r, err := CreateArchive(testFixture("archive-subdir"), &ArchiveOpts{})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	name := r.ReadCloser.(*readCloseRemover).F.Name()
+	if err := r.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Fatalf("expected %s to be removed: %v", name, err)
+	}
+}
+
+func TestList(t *testing.T) {
+	entries, err := List(testFixture("archive-subdir"), &ArchiveOpts{
+		Exclude: []string{"node_modules", "*.log", "subdir/*.log"},
+		Include: []string{"README.md", "subdir/*", "node_modules"},
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := []*Entry{
+		{Path: ".env", Size: 9, Reason: reasonInclude},
+		{Path: "README.md", Size: 6},
+		{Path: "node_modules", Dir: true, Reason: `matched exclude pattern "node_modules"`},
+		{Path: "subdir", Dir: true},
+		{Path: "subdir/app.log", Size: 2, Reason: `matched exclude pattern "subdir/*.log"`},
+		{Path: "subdir/hello.txt", Size: 2},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Fatalf("expected %s to be %s", testEntriesString(entries), testEntriesString(expected))
+	}
+}
+
+func TestList_includeDoublestar(t *testing.T) {
+	entries, err := List(testFixture("archive-subdir"), &ArchiveOpts{
+		Include: []string{"**/index.js"},
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Directories that may contain a match are only listed once something
+	// in them is included
+	expected := []*Entry{
+		{Path: ".env", Size: 9, Reason: reasonInclude},
+		{Path: "README.md", Size: 6, Reason: reasonInclude},
+		{Path: "node_modules", Dir: true},
+		{Path: "node_modules/dep", Dir: true},
+		{Path: "node_modules/dep/index.js", Size: 3},
+		{Path: "subdir", Dir: true, Reason: reasonInclude},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Fatalf("expected %s to be %s", testEntriesString(entries), testEntriesString(expected))
+	}
+}
+
+func TestList_ignoreFile(t *testing.T) {
+	entries, err := List(testFixture("archive-ignore"), &ArchiveOpts{
+		Include: []string{".env", "build", "keep.log"},
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var reasons []string
+	for _, e := range entries {
+		if e.Path == ".env" || e.Path == "build" || e.Path == "keep.log" {
+			reasons = append(reasons, e.Path+": "+e.Reason)
+		}
+	}
+
+	expected := []string{
+		`.env: matched ignore pattern ".env" (.atlasignore:2)`,
+		`build: matched ignore pattern "/build/" (.atlasignore:5)`,
+		`keep.log: `,
+	}
+	if !reflect.DeepEqual(reasons, expected) {
+		t.Fatalf("expected %#v to be %#v", reasons, expected)
+	}
+}
+
+func testArchiveEntries(t *testing.T, r io.Reader) []string {
+	gzipR, err := gzip.NewReader(r)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var entries []string
+	tarR := tar.NewReader(gzipR)
+	for {
+		hdr, err := tarR.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		entries = append(entries, hdr.Name)
+	}
+
+	sort.Strings(entries)
+	return entries
+}
+
+func testEntriesString(entries []*Entry) string {
+	var result string
+	for _, e := range entries {
+		result += "\n  " + e.Path
+		if e.Excluded() {
+			result += " (" + e.Reason + ")"
+		}
+	}
+
+	return result
+}
+
+func TestCreateArchiveContext_canceled(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "archive-test")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	oldTmpDir := os.Getenv("TMPDIR")
+	os.Setenv("TMPDIR", tmpDir)
+	defer os.Setenv("TMPDIR", oldTmpDir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = CreateArchiveContext(ctx, testFixture("archive-subdir"), &ArchiveOpts{})
+	if err != context.Canceled {
+		t.Fatalf("expected %v to be %v", err, context.Canceled)
+	}
+
+	files, err := ioutil.ReadDir(tmpDir)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(files) != 0 {
+		t.Fatalf("expected the temporary archive to be removed, found %s", files[0].Name())
+	}
+}
+
+func TestCreateArchive_contentHash(t *testing.T) {
+	dir := testReproducibleDir(t)
+	defer os.RemoveAll(dir)
+
+	hash := func() string {
+		r, err := CreateArchive(dir, &ArchiveOpts{})
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		defer r.Close()
+
+		if r.ContentHash == "" {
+			t.Fatal("expected a content hash")
+		}
+		return r.ContentHash
+	}
+	expected := hash()
+
+	// Times don't change the content hash
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "a.txt"), later, later); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if actual := hash(); actual != expected {
+		t.Fatalf("expected %s to be %s", actual, expected)
+	}
+
+	// Contents do
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.txt"), []byte("changed"), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if hash() == expected {
+		t.Fatal("expected the content hash to change")
+	}
+}
+
+func TestCreateArchive_checksums(t *testing.T) {
+	dir := testReproducibleDir(t)
+	defer os.RemoveAll(dir)
+
+	r, err := CreateArchive(dir, &ArchiveOpts{})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer r.Close()
+
+	sha, md := sha256.New(), md5.New()
+	if _, err := io.Copy(io.MultiWriter(sha, md), r); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if expected := hex.EncodeToString(sha.Sum(nil)); r.SHA256 != expected {
+		t.Fatalf("expected %q to be %q", r.SHA256, expected)
+	}
+	if expected := hex.EncodeToString(md.Sum(nil)); r.MD5 != expected {
+		t.Fatalf("expected %q to be %q", r.MD5, expected)
+	}
+}
@@ -0,0 +1,256 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Formats of archives. The archive of a directory can be created in any of
+// them but FormatTar, see ArchiveOpts.Format. A file that is already an
+// archive is passed through as-is instead of being archived, see
+// passthroughFormat.
+const (
+	FormatTarGzip = "tar.gz"
+	FormatTarZstd = "tar.zst"
+	FormatTarXz   = "tar.xz"
+	FormatZip     = "zip"
+	FormatTar     = "tar"
+)
+
+// Formats are the formats that the archive of a directory can be created
+// in. The first one is the default.
+var Formats = []string{FormatTarGzip, FormatTarZstd, FormatTarXz, FormatZip}
+
+// ValidFormat says whether the archive of a directory can be created in the
+// given format. The empty format is the default, FormatTarGzip.
+func ValidFormat(format string) bool {
+	if format == "" {
+		return true
+	}
+
+	for _, f := range Formats {
+		if f == format {
+			return true
+		}
+	}
+
+	return false
+}
+
+// formatMagic are the magic bytes that the formats start with, at the given
+// offset. The magic of tar is the one of the POSIX and GNU formats; older
+// tar files have none and are archived like any other file.
+var formatMagic = []struct {
+	format string
+	offset int
+	magic  []byte
+}{
+	{FormatTarGzip, 0, []byte{0x1f, 0x8b}},
+	{FormatTarZstd, 0, []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{FormatTarXz, 0, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+	{FormatZip, 0, []byte("PK\x03\x04")},
+	{FormatZip, 0, []byte("PK\x05\x06")},
+	{FormatTar, 257, []byte("ustar")},
+}
+
+// detectFormat returns the format of the archive at the given path, going by
+// the magic bytes it starts with, or "" if it is not an archive.
+func detectFormat(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	head := make([]byte, 262)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	head = head[:n]
+
+	for _, m := range formatMagic {
+		end := m.offset + len(m.magic)
+		if end <= len(head) && bytes.Equal(head[m.offset:end], m.magic) {
+			return m.format, nil
+		}
+	}
+
+	return "", nil
+}
+
+// passthroughFormat returns the format of the file at the given path if it
+// is an archive that is passed through as-is, or "" if it is archived like
+// any other file. A tar, compressed or not, always is. A zip only is if the
+// archive is created as a zip too (see ArchiveOpts.Format), since files such
+// as .jar files are zips as well and are still archived by default.
+func passthroughFormat(path, format string) (string, error) {
+	detected, err := detectFormat(path)
+	if err != nil {
+		return "", err
+	}
+
+	if detected == FormatZip && format != FormatZip {
+		log.Printf("[DEBUG] %s is a zip file, but archiving it since the "+
+			"archive format is not zip", path)
+		return "", nil
+	}
+
+	return detected, nil
+}
+
+// checkFormat checks that the archive of a directory can be created in the
+// given format at the given level of ArchiveOpts, and returns the format,
+// which defaults to FormatTarGzip.
+func checkFormat(format string, level int) (string, error) {
+	if format == "" {
+		format = FormatTarGzip
+	}
+	if !ValidFormat(format) {
+		return "", fmt.Errorf("unknown archive format %q", format)
+	}
+
+	if err := checkLevel(level); err != nil {
+		return "", err
+	}
+
+	switch {
+	case format == FormatTarZstd && level == NoCompression,
+		format == FormatTarXz && level != DefaultCompression:
+		return "", fmt.Errorf(
+			"compression level %d is not supported by the %s format", level, format)
+	}
+
+	return format, nil
+}
+
+// entryWriter writes the entries of an archive: the header of an entry,
+// followed by the data of a file. Closing it finishes the archive, but does
+// not close the underlying writer. Closing it again does nothing.
+type entryWriter interface {
+	io.WriteCloser
+	WriteHeader(header *tar.Header) error
+}
+
+// newEntryWriter returns the entryWriter that writes an archive in the given
+// format, which was checked by checkFormat, to w.
+func newEntryWriter(w io.Writer, format string, level int) (entryWriter, error) {
+	if format == FormatZip {
+		return newZipWriter(w, level), nil
+	}
+
+	var c io.WriteCloser
+	var err error
+	switch format {
+	case FormatTarGzip:
+		c, err = newParallelGzipWriter(w, level)
+	case FormatTarZstd:
+		c, err = zstd.NewWriter(w, zstd.WithEncoderLevel(zstdLevel(level)))
+	case FormatTarXz:
+		c, err = xz.NewWriter(w)
+	default:
+		err = fmt.Errorf("unknown archive format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &tarWriter{Writer: tar.NewWriter(c), compressor: c}, nil
+}
+
+// zstdLevel returns the zstd encoder level for the given level of
+// ArchiveOpts. The gzip levels map to the zstd levels of the same number.
+func zstdLevel(level int) zstd.EncoderLevel {
+	if level == DefaultCompression {
+		return zstd.SpeedDefault
+	}
+
+	return zstd.EncoderLevelFromZstd(level)
+}
+
+// tarWriter is the entryWriter of the tar formats. It writes a tar archive
+// to a compressor.
+type tarWriter struct {
+	*tar.Writer
+	compressor io.WriteCloser
+	closed     bool
+}
+
+func (t *tarWriter) Close() error {
+	if t.closed {
+		return nil
+	}
+	t.closed = true
+
+	err := t.Writer.Close()
+	if cerr := t.compressor.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// zipWriter is the entryWriter of FormatZip. It turns the tar headers into
+// the headers of zip entries.
+type zipWriter struct {
+	zw     *zip.Writer
+	w      io.Writer
+	method uint16
+	closed bool
+}
+
+func newZipWriter(w io.Writer, level int) *zipWriter {
+	z := &zipWriter{zw: zip.NewWriter(w), method: zip.Deflate}
+	if level == NoCompression {
+		z.method = zip.Store
+	} else {
+		z.zw.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(w, level)
+		})
+	}
+
+	return z
+}
+
+func (z *zipWriter) WriteHeader(header *tar.Header) error {
+	fh := &zip.FileHeader{Name: header.Name, Method: z.method}
+	fh.SetModTime(header.ModTime)
+	fh.SetMode(header.FileInfo().Mode())
+	if header.Typeflag == tar.TypeDir {
+		fh.Method = zip.Store
+	}
+
+	w, err := z.zw.CreateHeader(fh)
+	if err != nil {
+		return err
+	}
+
+	z.w = w
+	return nil
+}
+
+func (z *zipWriter) Write(p []byte) (int, error) {
+	if z.w == nil {
+		return 0, fmt.Errorf("zip: write before the header of an entry")
+	}
+
+	return z.w.Write(p)
+}
+
+func (z *zipWriter) Close() error {
+	if z.closed {
+		return nil
+	}
+	z.closed = true
+
+	return z.zw.Close()
+}
@@ -0,0 +1,252 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// testFormatEntries returns the sorted names of the entries of the archive
+// in the given format.
+func testFormatEntries(t *testing.T, format string, r io.Reader) []string {
+	var entries []string
+	if format == FormatZip {
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		for _, f := range zr.File {
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if _, err := io.Copy(ioutil.Discard, rc); err != nil {
+				t.Fatalf("%s: err: %s", f.Name, err)
+			}
+			rc.Close()
+
+			entries = append(entries, f.Name)
+		}
+
+		sort.Strings(entries)
+		return entries
+	}
+
+	var err error
+	switch format {
+	case FormatTarGzip:
+		r, err = gzip.NewReader(r)
+	case FormatTarZstd:
+		var d *zstd.Decoder
+		d, err = zstd.NewReader(r)
+		if err == nil {
+			defer d.Close()
+			r = d
+		}
+	case FormatTarXz:
+		r, err = xz.NewReader(r)
+	}
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	tarR := tar.NewReader(r)
+	for {
+		hdr, err := tarR.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		entries = append(entries, hdr.Name)
+	}
+
+	sort.Strings(entries)
+	return entries
+}
+
+func TestCreateArchive_format(t *testing.T) {
+	expected := []string{
+		".env",
+		"README.md",
+		"node_modules/",
+		"node_modules/dep/",
+		"node_modules/dep/index.js",
+		"subdir/",
+		"subdir/app.log",
+		"subdir/hello.txt",
+	}
+
+	for _, format := range Formats {
+		r, err := CreateArchive(testFixture("archive-subdir"), &ArchiveOpts{Format: format})
+		if err != nil {
+			t.Fatalf("%s: err: %s", format, err)
+		}
+
+		if r.Format != format {
+			t.Fatalf("%s: expected %q to be %q", format, r.Format, format)
+		}
+
+		entries := testFormatEntries(t, format, r)
+		r.Close()
+
+		if !reflect.DeepEqual(entries, expected) {
+			t.Fatalf("%s: expected %#v to be %#v", format, entries, expected)
+		}
+	}
+}
+
+func TestCreateArchive_formatInvalid(t *testing.T) {
+	cases := []struct {
+		Opts  *ArchiveOpts
+		Error string
+	}{
+		{&ArchiveOpts{Format: "rar"}, "unknown archive format"},
+		{&ArchiveOpts{Format: FormatTarZstd, CompressionLevel: testLevel(NoCompression)}, "not supported"},
+		{&ArchiveOpts{Format: FormatTarXz, CompressionLevel: testLevel(BestSpeed)}, "not supported"},
+	}
+
+	for _, tc := range cases {
+		_, err := CreateArchive(testFixture("archive-subdir"), tc.Opts)
+		if err == nil || !strings.Contains(err.Error(), tc.Error) {
+			t.Fatalf("%#v: expected an error with %q, got %v", tc.Opts, tc.Error, err)
+		}
+	}
+}
+
+func TestStream_format(t *testing.T) {
+	dir := testReproducibleDir(t)
+	defer os.RemoveAll(dir)
+
+	for _, format := range Formats {
+		s, err := NewStream(dir, &ArchiveOpts{Format: format, Reproducible: true})
+		if err != nil {
+			t.Fatalf("%s: err: %s", format, err)
+		}
+
+		// Every format writes the same archive every time
+		var first, second bytes.Buffer
+		if _, err := s.WriteContext(context.Background(), &first); err != nil {
+			t.Fatalf("%s: err: %s", format, err)
+		}
+		if _, err := s.WriteContext(context.Background(), &second); err != nil {
+			t.Fatalf("%s: err: %s", format, err)
+		}
+
+		if !bytes.Equal(first.Bytes(), second.Bytes()) {
+			t.Fatalf("%s: expected both writes to be the same", format)
+		}
+	}
+}
+
+func TestCreateArchive_passthrough(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Write an archive in every format, and a plain tar
+	paths := make(map[string]string)
+	for _, format := range append([]string{FormatTar}, Formats...) {
+		path := filepath.Join(dir, "archive."+format)
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		var ew entryWriter = tar.NewWriter(f)
+		if format != FormatTar {
+			ew, err = newEntryWriter(f, format, DefaultCompression)
+			if err != nil {
+				t.Fatalf("%s: err: %s", format, err)
+			}
+		}
+		ew.WriteHeader(&tar.Header{Name: "foo.txt", Mode: 0644, Size: 3, Typeflag: tar.TypeReg})
+		ew.Write([]byte("foo"))
+		if err := ew.Close(); err != nil {
+			t.Fatalf("%s: err: %s", format, err)
+		}
+		f.Close()
+
+		paths[format] = path
+	}
+
+	// A tar is passed through whatever the format of the archive, and a zip
+	// if the archive is a zip too
+	for format, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		opts := &ArchiveOpts{Format: FormatTarZstd}
+		if format == FormatZip {
+			opts.Format = FormatZip
+		}
+
+		r, err := CreateArchive(path, opts)
+		if err != nil {
+			t.Fatalf("%s: err: %s", format, err)
+		}
+		archived, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatalf("%s: err: %s", format, err)
+		}
+
+		if !bytes.Equal(archived, data) {
+			t.Fatalf("%s: expected the archive to be passed through", format)
+		}
+		if r.Format != format {
+			t.Fatalf("%s: expected %q to be %q", format, r.Format, format)
+		}
+
+		sum := sha256.Sum256(data)
+		if expected := hex.EncodeToString(sum[:]); r.SHA256 != expected {
+			t.Fatalf("%s: expected %q to be %q", format, r.SHA256, expected)
+		}
+	}
+
+	// A zip, like a .jar, and any other file are archived
+	path := filepath.Join(dir, "foo.txt")
+	if err := ioutil.WriteFile(path, []byte("foo"), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	for _, path := range []string{paths[FormatZip], path} {
+		r, err := CreateArchive(path, &ArchiveOpts{Format: FormatTarZstd})
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		entries := testFormatEntries(t, FormatTarZstd, r)
+		r.Close()
+
+		if expected := []string{filepath.Base(path)}; !reflect.DeepEqual(entries, expected) {
+			t.Fatalf("expected %#v to be %#v", entries, expected)
+		}
+	}
+}
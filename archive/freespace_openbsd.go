@@ -0,0 +1,14 @@
+package archive
+
+import "syscall"
+
+// dirFreeSpace returns the number of bytes that are available to unprivileged
+// users in the file system of the given directory.
+func dirFreeSpace(dir string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+
+	return int64(st.F_bavail) * int64(st.F_bsize), nil
+}
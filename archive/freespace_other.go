@@ -0,0 +1,15 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !openbsd && !windows
+// +build !darwin,!dragonfly,!freebsd,!linux,!openbsd,!windows
+
+package archive
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// dirFreeSpace is not supported on this platform, so a full disk is reported
+// with the error of the write that failed.
+func dirFreeSpace(dir string) (int64, error) {
+	return 0, fmt.Errorf("checking the free space is not supported on %s", runtime.GOOS)
+}
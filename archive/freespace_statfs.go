@@ -0,0 +1,17 @@
+//go:build darwin || dragonfly || freebsd || linux
+// +build darwin dragonfly freebsd linux
+
+package archive
+
+import "syscall"
+
+// dirFreeSpace returns the number of bytes that are available to unprivileged
+// users in the file system of the given directory.
+func dirFreeSpace(dir string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
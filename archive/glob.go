@@ -0,0 +1,258 @@
+package archive
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// globPattern is a compiled -include or -exclude pattern. It is matched
+// against the slash-separated path of an entry relative to the root of the
+// archive, with the semantics described by globRegexp.
+type globPattern struct {
+	// pattern is the pattern as it was given.
+	pattern string
+
+	// re matches the paths that the pattern matches, and parents matches
+	// the directories that may contain such a path.
+	re      *regexp.Regexp
+	parents []*regexp.Regexp
+}
+
+// compileGlobs compiles the given patterns.
+func compileGlobs(patterns []string) ([]*globPattern, error) {
+	result := make([]*globPattern, 0, len(patterns))
+	for _, pattern := range patterns {
+		p, err := compileGlob(pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, p)
+	}
+
+	return result, nil
+}
+
+func compileGlob(pattern string) (*globPattern, error) {
+	// A leading "./" or "/" is the root itself
+	clean := strings.TrimPrefix(strings.TrimPrefix(pattern, "./"), "/")
+	clean = strings.TrimSuffix(clean, "/")
+
+	re, err := globRegexp(clean)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %s", pattern, err)
+	}
+
+	// Every leading part of the pattern matches the directories that the
+	// pattern can match below, so "a/*/c" can match below "a" and "a/b".
+	segments := strings.Split(clean, "/")
+	parents := make([]*regexp.Regexp, 0, len(segments)-1)
+	for i := 1; i < len(segments); i++ {
+		re, err := globRegexp(strings.Join(segments[:i], "/"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %s", pattern, err)
+		}
+
+		parents = append(parents, re)
+	}
+
+	return &globPattern{pattern: pattern, re: re, parents: parents}, nil
+}
+
+// match says whether the pattern matches the entry with the given path.
+func (p *globPattern) match(entry string) bool {
+	return p.re.MatchString(entry)
+}
+
+// matchParent says whether the directory with the given path may contain an
+// entry that the pattern matches.
+func (p *globPattern) matchParent(dir string) bool {
+	for _, re := range p.parents {
+		if re.MatchString(dir) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// quoteGlob escapes the special characters in the given name, so that it
+// can be used as a pattern that only matches itself.
+func quoteGlob(name string) string {
+	var buf bytes.Buffer
+	for _, c := range name {
+		switch c {
+		case '*', '?', '[', '\\':
+			buf.WriteRune('\\')
+		}
+		buf.WriteRune(c)
+	}
+
+	return buf.String()
+}
+
+// globRegexp compiles a slash-separated glob pattern to a regular expression
+// that matches the whole slash-separated path. "*" and "?" don't match a
+// slash, "[...]" is a character class as described by writeClassRegexp and
+// a backslash escapes the next character. A "**" segment matches any number of directories: "**/a"
+// matches "a" at any depth, "a/**" matches everything inside "a" and
+// "a/**/b" matches "a/b", "a/x/b", "a/x/y/b" and so on.
+func globRegexp(pattern string) (*regexp.Regexp, error) {
+	var buf bytes.Buffer
+	buf.WriteString("^")
+
+	segments := strings.Split(pattern, "/")
+	for i, segment := range segments {
+		last := i == len(segments)-1
+		if segment == "**" {
+			if last {
+				buf.WriteString(".*")
+			} else {
+				buf.WriteString("(?:.*/)?")
+			}
+			continue
+		}
+
+		if err := writeSegmentRegexp(&buf, segment); err != nil {
+			return nil, err
+		}
+		if !last {
+			buf.WriteString("/")
+		}
+	}
+
+	buf.WriteString("$")
+	return regexp.Compile(buf.String())
+}
+
+// writeSegmentRegexp writes the regular expression for a single segment of a
+// glob pattern, which doesn't contain a slash.
+func writeSegmentRegexp(buf *bytes.Buffer, segment string) error {
+	for i := 0; i < len(segment); i++ {
+		c := segment[i]
+		switch c {
+		case '*':
+			buf.WriteString("[^/]*")
+
+			// Within a segment, "**" is the same as "*"
+			for i+1 < len(segment) && segment[i+1] == '*' {
+				i++
+			}
+		case '?':
+			buf.WriteString("[^/]")
+		case '\\':
+			if i+1 == len(segment) {
+				return fmt.Errorf("trailing backslash")
+			}
+			i++
+			buf.WriteString(regexp.QuoteMeta(segment[i : i+1]))
+		case '[':
+			n, err := writeClassRegexp(buf, segment[i+1:])
+			if err != nil {
+				return err
+			}
+			i += n
+		default:
+			buf.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	return nil
+}
+
+// writeClassRegexp writes the regular expression for the character class
+// that the given part of a segment starts with, after its "[", and returns
+// the length of the class up to and including its "]". A class is defined
+// like in filepath.Match: it is a non-empty list of characters and ranges
+// "lo-hi", where a backslash escapes the next character, which it must for
+// a backslash, "-" and "]". A leading "^" or "!" negates it. A class never
+// matches a slash, and since the pattern is split at slashes first, it can't
+// contain one either.
+func writeClassRegexp(buf *bytes.Buffer, class string) (int, error) {
+	i := 0
+	negated := false
+	if i < len(class) && (class[i] == '^' || class[i] == '!') {
+		negated = true
+		i++
+	}
+
+	var ranges bytes.Buffer
+	for {
+		if i == len(class) {
+			return 0, fmt.Errorf("unclosed character class")
+		}
+		if class[i] == ']' && ranges.Len() > 0 {
+			i++
+			break
+		}
+
+		lo, n, err := classChar(class[i:])
+		if err != nil {
+			return 0, err
+		}
+		i += n
+
+		hi := lo
+		if i < len(class) && class[i] == '-' {
+			hi, n, err = classChar(class[i+1:])
+			if err != nil {
+				return 0, err
+			}
+			i += n + 1
+
+			if hi < lo {
+				return 0, fmt.Errorf("invalid character range %c-%c", lo, hi)
+			}
+		}
+
+		// Leave the slash out of a range that spans it. A negated class
+		// excludes it below.
+		if !negated && lo <= '/' && hi >= '/' {
+			if lo < '/' {
+				writeClassRange(&ranges, lo, '/'-1)
+			}
+			if hi > '/' {
+				writeClassRange(&ranges, '/'+1, hi)
+			}
+			continue
+		}
+		writeClassRange(&ranges, lo, hi)
+	}
+
+	buf.WriteString("[")
+	if negated {
+		buf.WriteString("^/")
+	}
+	buf.Write(ranges.Bytes())
+	buf.WriteString("]")
+	return i, nil
+}
+
+// classChar returns the character that the given part of a character class
+// starts with, unescaping it, and its length in the pattern.
+func classChar(class string) (rune, int, error) {
+	n := 0
+	if strings.HasPrefix(class, `\`) {
+		n++
+	} else if strings.HasPrefix(class, "-") || strings.HasPrefix(class, "]") {
+		return 0, 0, fmt.Errorf("unescaped %q in character class", class[0])
+	}
+	if n == len(class) {
+		return 0, 0, fmt.Errorf("unclosed character class")
+	}
+
+	c, size := utf8.DecodeRuneInString(class[n:])
+	return c, n + size, nil
+}
+
+// writeClassRange writes the range of characters from lo to hi within a
+// character class of a regular expression.
+func writeClassRange(buf *bytes.Buffer, lo, hi rune) {
+	fmt.Fprintf(buf, `\x{%x}`, lo)
+	if hi != lo {
+		fmt.Fprintf(buf, `-\x{%x}`, hi)
+	}
+}
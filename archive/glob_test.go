@@ -0,0 +1,121 @@
+package archive
+
+import (
+	"testing"
+)
+
+func TestGlobRegexp(t *testing.T) {
+	cases := []struct {
+		Pattern string
+		Path    string
+		Match   bool
+	}{
+		{"*.log", "app.log", true},
+		{"*.log", "logs/app.log", false},
+		{"**/*.log", "app.log", true},
+		{"**/*.log", "logs/app/x.log", true},
+		{"logs/**", "logs/app/x.log", true},
+		{"logs/**", "logs", false},
+		{"a/**/b", "a/b", true},
+		{"a/**/b", "a/x/y/b", true},
+		{"a/**/b", "a/x/y/c", false},
+		{"a/*/b", "a/x/y/b", false},
+		{"file?.txt", "file1.txt", true},
+		{"file?.txt", "file/.txt", false},
+		{"[abc].txt", "b.txt", true},
+		{"[!abc].txt", "d.txt", true},
+		{"[!abc].txt", "a.txt", false},
+		{"[^abc].txt", "d.txt", true},
+		{"[^abc].txt", "a.txt", false},
+		{"[a-c].txt", "b.txt", true},
+		{"[a-c].txt", "d.txt", false},
+		{"[!a-c].txt", "d.txt", true},
+		{"[!a-c].txt", "b.txt", false},
+		{"a[!b]c", "a/c", false},
+		{"a[.-0]c", "a.c", true},
+		{"a[.-0]c", "a0c", true},
+		{"a[.-0]c", "a/c", false},
+		{`[\]].txt`, "].txt", true},
+		{`[\-].txt`, "-.txt", true},
+		{`[\\].txt`, `\.txt`, true},
+		{"[.].txt", "..txt", true},
+		{"[.].txt", "a.txt", false},
+		{"[é].txt", "é.txt", true},
+		{`\*.txt`, "*.txt", true},
+		{`\*.txt`, "a.txt", false},
+		{"a.b", "axb", false},
+	}
+
+	for _, tc := range cases {
+		re, err := globRegexp(tc.Pattern)
+		if err != nil {
+			t.Fatalf("%s: err: %s", tc.Pattern, err)
+		}
+
+		if match := re.MatchString(tc.Path); match != tc.Match {
+			t.Errorf("expected %q matching %q to be %t", tc.Pattern, tc.Path, tc.Match)
+		}
+	}
+}
+
+func TestGlobRegexp_invalid(t *testing.T) {
+	patterns := []string{
+		"[abc", `foo\`, "[", "[!", "[]", "[]]", "[!]]", "[a-", "[a-]", "[-a]",
+		"[z-a]", `[a\`, "[/]", "a[/]b",
+	}
+	for _, pattern := range patterns {
+		if _, err := globRegexp(pattern); err == nil {
+			t.Errorf("%s: expected error", pattern)
+		}
+	}
+}
+
+func TestGlobPattern(t *testing.T) {
+	cases := []struct {
+		Pattern string
+		Path    string
+		Match   bool
+		Parent  bool
+	}{
+		{"*.log", "app.log", true, false},
+		{"*.log", "logs", false, false},
+		{"**/*.log", "logs/app/x.log", true, true},
+		{"**/*.log", "logs/app", false, true},
+		{"logs/*/x.log", "logs", false, true},
+		{"logs/*/x.log", "logs/app", false, true},
+		{"logs/*/x.log", "logs/app/deep", false, false},
+		{"logs/**/x.log", "logs/app/deep", false, true},
+		{"logs/**/x.log", "other", false, false},
+		{"./app/", "app", true, false},
+		{"/app", "app", true, false},
+	}
+
+	for _, tc := range cases {
+		p, err := compileGlob(tc.Pattern)
+		if err != nil {
+			t.Fatalf("%s: err: %s", tc.Pattern, err)
+		}
+
+		if match := p.match(tc.Path); match != tc.Match {
+			t.Errorf("expected %q matching %q to be %t", tc.Pattern, tc.Path, tc.Match)
+		}
+		if parent := p.matchParent(tc.Path); parent != tc.Parent {
+			t.Errorf("expected %q matching below %q to be %t", tc.Pattern, tc.Path, tc.Parent)
+		}
+	}
+}
+
+func TestQuoteGlob(t *testing.T) {
+	name := `a*b?c[d]\e.txt`
+	p, err := compileGlob(quoteGlob(name))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if !p.match(name) {
+		t.Fatalf("expected %q to match itself", name)
+	}
+	if p.match("aXb?c[d]\\e.txt") {
+		t.Fatalf("expected %q to only match itself", name)
+	}
+}
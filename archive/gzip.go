@@ -0,0 +1,253 @@
+package archive
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// Compression levels for ArchiveOpts, which are the ones of compress/gzip.
+// Any level from BestSpeed to BestCompression may be used as well.
+const (
+	NoCompression      = gzip.NoCompression
+	BestSpeed          = gzip.BestSpeed
+	BestCompression    = gzip.BestCompression
+	DefaultCompression = gzip.DefaultCompression
+)
+
+// checkLevel checks that the given compression level is one of the levels
+// of compress/gzip.
+func checkLevel(level int) error {
+	if level != DefaultCompression && (level < NoCompression || level > BestCompression) {
+		return fmt.Errorf("invalid compression level %d", level)
+	}
+
+	return nil
+}
+
+const (
+	// gzipBlockSize is the size of the blocks of the input that are
+	// compressed concurrently. Larger blocks compress slightly better,
+	// smaller blocks are spread over more cores for small archives.
+	gzipBlockSize = 1 << 20
+
+	// gzipDictSize is the size of the window of deflate, which is how much
+	// of the previous block is used as the dictionary of the next one.
+	gzipDictSize = 32 << 10
+)
+
+// parallelGzipWriter is a gzip writer that compresses blocks of its input on
+// all cores at the same time. Every block is compressed on its own, with the
+// end of the previous block as its dictionary, and the blocks are flushed to
+// the byte boundary so that they can simply be concatenated: the output is a
+// single standard gzip member that any gzip reader can read.
+//
+// Since the blocks don't depend on how many of them are compressed at once,
+// the output is the same no matter how many cores there are, which keeps
+// reproducible archives reproducible.
+type parallelGzipWriter struct {
+	w     io.Writer
+	level int
+
+	block []byte
+	dict  []byte
+	crc   hash.Hash32
+	size  uint32
+
+	// blocks are the blocks that are being compressed, in order, and sem
+	// limits how many are compressed at once. done is closed when every
+	// block was written to w.
+	blocks chan *gzipBlock
+	sem    chan struct{}
+	done   chan struct{}
+
+	mu     sync.Mutex
+	err    error
+	closed bool
+}
+
+// gzipBlock is a block of the input and the result of its compression.
+type gzipBlock struct {
+	out []byte
+	err error
+
+	// compressed is closed once out or err is set.
+	compressed chan struct{}
+}
+
+// newParallelGzipWriter returns a gzip writer with the given compress/gzip
+// level that writes to w. It must be closed to write the end of the stream,
+// and to stop the goroutines that compress and write the blocks.
+func newParallelGzipWriter(w io.Writer, level int) (*parallelGzipWriter, error) {
+	if err := checkLevel(level); err != nil {
+		return nil, err
+	}
+
+	n := runtime.GOMAXPROCS(0)
+	z := &parallelGzipWriter{
+		w:      w,
+		level:  level,
+		block:  make([]byte, 0, gzipBlockSize),
+		crc:    crc32.NewIEEE(),
+		blocks: make(chan *gzipBlock, n),
+		sem:    make(chan struct{}, n),
+		done:   make(chan struct{}),
+	}
+
+	if err := z.writeHeader(); err != nil {
+		return nil, err
+	}
+
+	go z.writeBlocks()
+	return z, nil
+}
+
+// writeHeader writes the same header as a gzip.Writer without a name or a
+// modification time.
+func (z *parallelGzipWriter) writeHeader() error {
+	header := []byte{0x1f, 0x8b, 8, 0, 0, 0, 0, 0, 0, 255}
+	switch z.level {
+	case gzip.BestCompression:
+		header[8] = 2
+	case gzip.BestSpeed:
+		header[8] = 4
+	}
+
+	_, err := z.w.Write(header)
+	return err
+}
+
+func (z *parallelGzipWriter) Write(p []byte) (int, error) {
+	if err := z.error(); err != nil {
+		return 0, err
+	}
+
+	n := len(p)
+	z.crc.Write(p)
+	z.size += uint32(n)
+	for len(p) > 0 {
+		c := copy(z.block[len(z.block):cap(z.block)], p)
+		z.block = z.block[:len(z.block)+c]
+		p = p[c:]
+
+		if len(z.block) == cap(z.block) {
+			z.compress(false)
+		}
+	}
+
+	return n, nil
+}
+
+// Close compresses what is left, waits for every block to be written and
+// writes the end of the stream. It does not close the underlying writer.
+func (z *parallelGzipWriter) Close() error {
+	z.mu.Lock()
+	closed := z.closed
+	z.closed = true
+	z.mu.Unlock()
+	if closed {
+		return z.error()
+	}
+
+	// The last block is always compressed, even if it is empty, since it
+	// ends the deflate stream
+	z.compress(true)
+	close(z.blocks)
+	<-z.done
+
+	if err := z.error(); err != nil {
+		return err
+	}
+
+	trailer := make([]byte, 8)
+	binary.LittleEndian.PutUint32(trailer[:4], z.crc.Sum32())
+	binary.LittleEndian.PutUint32(trailer[4:], z.size)
+	_, err := z.w.Write(trailer)
+	return err
+}
+
+// compress starts compressing the current block and starts a new one.
+func (z *parallelGzipWriter) compress(final bool) {
+	b := &gzipBlock{compressed: make(chan struct{})}
+	data, dict := z.block, z.dict
+
+	// Queue the block before waiting for a free core, so that the blocks
+	// are written in order
+	z.blocks <- b
+	z.sem <- struct{}{}
+	go func() {
+		defer func() { <-z.sem }()
+		b.out, b.err = compressGzipBlock(z.level, dict, data, final)
+		close(b.compressed)
+	}()
+
+	// Every block but the last is full, so the dictionary of the next one
+	// is the end of this one. The block is still being read, so the next
+	// one needs its own buffer.
+	if !final {
+		z.dict = data[len(data)-gzipDictSize:]
+		z.block = make([]byte, 0, gzipBlockSize)
+	}
+}
+
+// writeBlocks writes the compressed blocks to the underlying writer in
+// order, until the blocks channel is closed.
+func (z *parallelGzipWriter) writeBlocks() {
+	defer close(z.done)
+
+	for b := range z.blocks {
+		<-b.compressed
+		if z.error() != nil {
+			continue
+		}
+
+		err := b.err
+		if err == nil {
+			_, err = z.w.Write(b.out)
+		}
+		if err != nil {
+			z.mu.Lock()
+			z.err = err
+			z.mu.Unlock()
+		}
+	}
+}
+
+func (z *parallelGzipWriter) error() error {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	return z.err
+}
+
+// compressGzipBlock compresses a block of the input with the given
+// dictionary. The last block ends the deflate stream, every other block is
+// flushed to the byte boundary so that the next block can follow it.
+func compressGzipBlock(level int, dict, data []byte, final bool) ([]byte, error) {
+	var buf bytes.Buffer
+	fw, err := flate.NewWriterDict(&buf, level, dict)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := fw.Write(data); err != nil {
+		return nil, err
+	}
+
+	if final {
+		err = fw.Close()
+	} else {
+		err = fw.Flush()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
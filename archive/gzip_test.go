@@ -0,0 +1,229 @@
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testGzipData returns n bytes of compressible data that looks somewhat like
+// source code.
+func testGzipData(n int) []byte {
+	words := strings.Fields(`package main import func return if err != nil
+		for range var const type struct interface map string int byte
+		{ } ( ) := = "fmt" "os" log.Printf("[INFO] %s", path)`)
+
+	r := rand.New(rand.NewSource(42))
+	var buf bytes.Buffer
+	for buf.Len() < n {
+		buf.WriteString(words[r.Intn(len(words))])
+		if r.Intn(8) == 0 {
+			buf.WriteByte('\n')
+		} else {
+			buf.WriteByte(' ')
+		}
+	}
+
+	return buf.Bytes()[:n]
+}
+
+// testLevel returns the given compression level for ArchiveOpts.
+func testLevel(level int) *int {
+	return &level
+}
+
+func testParallelGzip(t *testing.T, data []byte, level int) []byte {
+	var buf bytes.Buffer
+	z, err := newParallelGzipWriter(&buf, level)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Write in odd sizes so that writes span the blocks
+	for p := data; len(p) > 0; {
+		n := 100003
+		if n > len(p) {
+			n = len(p)
+		}
+		if _, err := z.Write(p[:n]); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		p = p[n:]
+	}
+	if err := z.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestParallelGzipWriter(t *testing.T) {
+	sizes := []int{0, 100, gzipBlockSize, 3*gzipBlockSize + 17}
+	levels := []int{gzip.DefaultCompression, gzip.NoCompression, gzip.BestSpeed, gzip.BestCompression}
+
+	for _, size := range sizes {
+		data := testGzipData(size)
+		for _, level := range levels {
+			compressed := testParallelGzip(t, data, level)
+
+			r, err := gzip.NewReader(bytes.NewReader(compressed))
+			if err != nil {
+				t.Fatalf("%d bytes at level %d: err: %s", size, level, err)
+			}
+			out, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatalf("%d bytes at level %d: err: %s", size, level, err)
+			}
+
+			if !bytes.Equal(out, data) {
+				t.Fatalf("%d bytes at level %d: expected the data to round trip", size, level)
+			}
+		}
+	}
+}
+
+func TestParallelGzipWriter_deterministic(t *testing.T) {
+	data := testGzipData(3*gzipBlockSize + 17)
+
+	procs := runtime.GOMAXPROCS(1)
+	defer runtime.GOMAXPROCS(procs)
+	serial := testParallelGzip(t, data, gzip.DefaultCompression)
+
+	runtime.GOMAXPROCS(4)
+	parallel := testParallelGzip(t, data, gzip.DefaultCompression)
+
+	if !bytes.Equal(serial, parallel) {
+		t.Fatal("expected the output not to depend on the number of cores")
+	}
+}
+
+func TestParallelGzipWriter_writeError(t *testing.T) {
+	z, err := newParallelGzipWriter(&failingWriter{n: 1}, gzip.DefaultCompression)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	data := testGzipData(2 * gzipBlockSize)
+	z.Write(data)
+	if err := z.Close(); err != io.ErrShortWrite {
+		t.Fatalf("expected %v to be %v", err, io.ErrShortWrite)
+	}
+}
+
+func TestCreateArchive_walkErrorStopsCompressor(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	// The extra file doesn't exist, so the walk fails after the compressor
+	// was started
+	_, err := CreateArchive(testFixture("archive-subdir"), &ArchiveOpts{
+		Extra: map[string]string{"missing.txt": testFixture("missing.txt")},
+	})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	// The goroutines may take a moment to return
+	for i := 0; runtime.NumGoroutine() > before; i++ {
+		if i == 100 {
+			t.Fatalf("expected %d goroutines, got %d", before, runtime.NumGoroutine())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// failingWriter fails every write after the first n.
+type failingWriter struct {
+	n int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.n == 0 {
+		return 0, io.ErrShortWrite
+	}
+	w.n--
+	return len(p), nil
+}
+
+func TestCreateArchive_compressionLevel(t *testing.T) {
+	sizes := make(map[int]int64)
+	for _, level := range []int{NoCompression, DefaultCompression, BestCompression} {
+		r, err := CreateArchive(testFixture("archive-subdir"), &ArchiveOpts{
+			CompressionLevel: testLevel(level),
+		})
+		if err != nil {
+			t.Fatalf("level %d: err: %s", level, err)
+		}
+
+		entries := testArchiveEntries(t, r)
+		r.Close()
+		if len(entries) != 8 {
+			t.Fatalf("level %d: expected 8 entries, got %#v", level, entries)
+		}
+
+		sizes[level] = r.Size
+	}
+
+	if sizes[NoCompression] <= sizes[DefaultCompression] {
+		t.Fatalf("expected the stored archive to be larger: %#v", sizes)
+	}
+
+	// Without a level, the default level is used
+	r, err := CreateArchive(testFixture("archive-subdir"), new(ArchiveOpts))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	r.Close()
+	if r.Size != sizes[DefaultCompression] {
+		t.Fatalf("expected %d to be %d", r.Size, sizes[DefaultCompression])
+	}
+
+	_, err = CreateArchive(testFixture("archive-subdir"), &ArchiveOpts{CompressionLevel: testLevel(10)})
+	if err == nil || !strings.Contains(err.Error(), "invalid compression level") {
+		t.Fatalf("expected an error, got %v", err)
+	}
+}
+
+// The benchmarks compare the throughput of gzip.Writer, which archives were
+// compressed with before, with parallelGzipWriter.
+func BenchmarkGzipWriter(b *testing.B) {
+	data := testGzipData(8 << 20)
+
+	for _, level := range []int{gzip.BestSpeed, gzip.DefaultCompression, gzip.BestCompression} {
+		b.Run(fmt.Sprintf("level=%d", level), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				z, err := gzip.NewWriterLevel(ioutil.Discard, level)
+				if err != nil {
+					b.Fatal(err)
+				}
+				z.Write(data)
+				z.Close()
+			}
+		})
+	}
+}
+
+func BenchmarkParallelGzipWriter(b *testing.B) {
+	data := testGzipData(8 << 20)
+
+	for _, level := range []int{gzip.BestSpeed, gzip.DefaultCompression, gzip.BestCompression} {
+		b.Run(fmt.Sprintf("level=%d", level), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				z, err := newParallelGzipWriter(ioutil.Discard, level)
+				if err != nil {
+					b.Fatal(err)
+				}
+				z.Write(data)
+				z.Close()
+			}
+		})
+	}
+}
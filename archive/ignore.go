@@ -0,0 +1,221 @@
+package archive
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// IgnoreFile is the name of the file that lists the paths to leave out of
+// the archive, using the same syntax as a .gitignore file.
+const IgnoreFile = ".atlasignore"
+
+// ignoreRule is a single pattern from an ignore file.
+type ignoreRule struct {
+	// pattern is the pattern as it was written in the file, and source is
+	// where it was written (the ignore file and line).
+	pattern string
+	source  string
+
+	// negate is true for patterns starting with "!", which include paths
+	// again that an earlier pattern excluded.
+	negate bool
+
+	// dirOnly is true for patterns ending with "/", which only match
+	// directories.
+	dirOnly bool
+
+	// re matches the slash-separated path relative to the directory of the
+	// ignore file.
+	re *regexp.Regexp
+}
+
+// ignoreMatcher holds the rules of the ignore files that apply to a walk,
+// keyed by the slash-separated path of the directory the file is in, which
+// is "" for the root.
+type ignoreMatcher struct {
+	nested bool
+	rules  map[string][]*ignoreRule
+}
+
+// newIgnoreMatcher returns an ignoreMatcher with the rules of the ignore file
+// in root, if there is one. If nested is true, ignore files in the
+// directories below root are read as they are walked; see loadDir.
+func newIgnoreMatcher(root string, nested bool) (*ignoreMatcher, error) {
+	m := &ignoreMatcher{
+		nested: nested,
+		rules:  make(map[string][]*ignoreRule),
+	}
+	if err := m.load("", root); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// loadDir reads the ignore file in the directory with the given entry path
+// and real path, if nested ignore files are enabled. Its rules apply to
+// everything below that directory and take precedence over the rules of
+// ignore files higher up.
+func (m *ignoreMatcher) loadDir(entry, dir string) error {
+	if m == nil || !m.nested {
+		return nil
+	}
+
+	return m.load(entry, dir)
+}
+
+func (m *ignoreMatcher) load(entry, dir string) error {
+	f, err := os.Open(filepath.Join(dir, IgnoreFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	source := path.Join(entry, IgnoreFile)
+	rules, err := parseIgnore(f, source)
+	if err != nil {
+		return err
+	}
+
+	m.rules[entry] = rules
+	return nil
+}
+
+// match returns the rule that excludes the entry with the given path, or nil
+// if it is not excluded. As with .gitignore, the last rule that matches
+// decides, and a negated rule includes the entry again.
+func (m *ignoreMatcher) match(entry string, dir bool) *ignoreRule {
+	if m == nil || len(m.rules) == 0 {
+		return nil
+	}
+
+	// Check the rules from the root down to the parent of the entry, so
+	// that the rules of the deepest ignore file come last
+	var result *ignoreRule
+	parents := append([]string{""}, parentDirs(entry)...)
+	for _, parent := range parents {
+		rules, ok := m.rules[parent]
+		if !ok {
+			continue
+		}
+
+		rel := entry
+		if parent != "" {
+			rel = strings.TrimPrefix(entry, parent+"/")
+		}
+
+		for _, rule := range rules {
+			if rule.dirOnly && !dir {
+				continue
+			}
+			if !rule.re.MatchString(rel) {
+				continue
+			}
+
+			result = rule
+			if rule.negate {
+				result = nil
+			}
+		}
+	}
+
+	return result
+}
+
+// parentDirs returns the parent directories of the slash-separated path, from
+// the top down, for example "a" and "a/b" for "a/b/c".
+func parentDirs(entry string) []string {
+	var dirs []string
+	for i := 0; i < len(entry); i++ {
+		if entry[i] == '/' {
+			dirs = append(dirs, entry[:i])
+		}
+	}
+
+	return dirs
+}
+
+// parseIgnore parses the rules of an ignore file. The source is used to say
+// where a rule came from.
+func parseIgnore(r io.Reader, source string) ([]*ignoreRule, error) {
+	var rules []*ignoreRule
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimRight(scanner.Text(), "\r")
+
+		// Blank lines and comments don't match anything
+		if text == "" || text[0] == '#' {
+			continue
+		}
+
+		// Trailing spaces are ignored unless they are escaped
+		text = trimTrailingSpace(text)
+		if text == "" {
+			continue
+		}
+
+		rule := &ignoreRule{
+			pattern: text,
+			source:  fmt.Sprintf("%s:%d", source, line),
+		}
+
+		if text[0] == '!' {
+			rule.negate = true
+			text = text[1:]
+		} else if strings.HasPrefix(text, `\!`) || strings.HasPrefix(text, `\#`) {
+			text = text[1:]
+		}
+
+		if strings.HasSuffix(text, "/") {
+			rule.dirOnly = true
+			text = strings.TrimRight(text, "/")
+		}
+		if text == "" {
+			continue
+		}
+
+		// A pattern with a slash at the start or in the middle is relative
+		// to the directory of the ignore file, any other pattern matches at
+		// any depth.
+		if strings.HasPrefix(text, "/") {
+			text = text[1:]
+		} else if !strings.Contains(text, "/") {
+			text = "**/" + text
+		}
+
+		re, err := globRegexp(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid pattern %q: %s", rule.source, rule.pattern, err)
+		}
+		rule.re = re
+
+		rules = append(rules, rule)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading %s: %s", source, err)
+	}
+
+	return rules, nil
+}
+
+// trimTrailingSpace removes the trailing spaces of an ignore file line, but
+// keeps a space that is escaped with a backslash.
+func trimTrailingSpace(text string) string {
+	for strings.HasSuffix(text, " ") && !strings.HasSuffix(text, `\ `) {
+		text = text[:len(text)-1]
+	}
+
+	return text
+}
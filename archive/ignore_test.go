@@ -0,0 +1,88 @@
+package archive
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIgnoreMatcher(t *testing.T) {
+	input := `
+# comment
+\#hash
+*.log
+!keep.log
+/build/
+docs/*.md
+tmp/
+trailing   
+`
+	rules, err := parseIgnore(strings.NewReader(input), IgnoreFile)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	m := &ignoreMatcher{rules: map[string][]*ignoreRule{"": rules}}
+
+	cases := []struct {
+		Path    string
+		Dir     bool
+		Pattern string
+	}{
+		{"#hash", false, `\#hash`},
+		{"comment", false, ""},
+		{"app.log", false, "*.log"},
+		{"logs/deep/app.log", false, "*.log"},
+		{"keep.log", false, ""},
+		{"logs/keep.log", false, ""},
+		{"build", true, "/build/"},
+		{"build", false, ""},
+		{"src/build", true, ""},
+		{"docs/readme.md", false, "docs/*.md"},
+		{"src/docs/readme.md", false, ""},
+		{"tmp", true, "tmp/"},
+		{"src/tmp", true, "tmp/"},
+		{"trailing", false, "trailing"},
+	}
+
+	for _, tc := range cases {
+		rule := m.match(tc.Path, tc.Dir)
+		pattern := ""
+		if rule != nil {
+			pattern = rule.pattern
+		}
+
+		if pattern != tc.Pattern {
+			t.Errorf("%s: expected to match %q, got %q", tc.Path, tc.Pattern, pattern)
+		}
+	}
+}
+
+func TestIgnoreMatcher_nested(t *testing.T) {
+	root, err := parseIgnore(strings.NewReader("*.log\nsecret.txt\n"), IgnoreFile)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	sub, err := parseIgnore(strings.NewReader("!app.log\n/local.txt\n"), "sub/"+IgnoreFile)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	m := &ignoreMatcher{rules: map[string][]*ignoreRule{"": root, "sub": sub}}
+
+	cases := []struct {
+		Path     string
+		Excluded bool
+	}{
+		{"app.log", true},
+		{"sub/app.log", false},
+		{"sub/deep/app.log", false},
+		{"sub/secret.txt", true},
+		{"sub/local.txt", true},
+		{"sub/deep/local.txt", false},
+		{"local.txt", false},
+	}
+
+	for _, tc := range cases {
+		if excluded := m.match(tc.Path, false) != nil; excluded != tc.Excluded {
+			t.Errorf("%s: expected excluded to be %t", tc.Path, tc.Excluded)
+		}
+	}
+}
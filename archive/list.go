@@ -0,0 +1,101 @@
+package archive
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// Entry is a single path that was considered while walking the path to
+// archive.
+type Entry struct {
+	// Path is the slash-separated path of the entry within the archive.
+	Path string
+
+	// Size is the size of the file in bytes. It is zero for directories.
+	Size int64
+
+	// Dir is true if the entry is a directory.
+	Dir bool
+
+	// Reason is the reason the entry was excluded from the archive, such as
+	// the exclude pattern that matched it. It is empty for entries that are
+	// included in the archive.
+	Reason string
+}
+
+// Excluded says whether the entry is excluded from the archive.
+func (e *Entry) Excluded() bool {
+	return e.Reason != ""
+}
+
+// List walks the given path with the same rules as CreateArchive and returns
+// the entries that it considered, in the order they would be archived. No
+// archive is created.
+//
+// Excluded entries are returned as well, with the reason they were excluded.
+// When a directory is excluded, its children are not walked and therefore
+// not returned.
+//
+// If the path is an archive, it would be uploaded as-is, so the file itself
+// is the only entry.
+func List(path string, opts *ArchiveOpts) ([]*Entry, error) {
+	log.Printf("[INFO] listing archive entries for %s", path)
+
+	path, fi, err := resolvePath(path, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if fi.IsDir() {
+		return listDir(path, opts)
+	}
+
+	format, err := passthroughFormat(path, opts.Format)
+	if err != nil {
+		return nil, err
+	}
+	if format != "" {
+		return []*Entry{{Path: filepath.Base(path), Size: fi.Size()}}, nil
+	}
+
+	// Act like we're listing a directory, but only include this one file.
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return listDir(filepath.Dir(path), &ArchiveOpts{
+		Include:      []string{quoteGlob(filepath.Base(path))},
+		NoIgnoreFile: true,
+	})
+}
+
+// listDir returns the entries that the archive of the directory would have,
+// by walking it like the dirArchiver that writes the archive.
+func listDir(root string, opts *ArchiveOpts) ([]*Entry, error) {
+	d, err := newDirArchiver(root, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*Entry
+	err = d.walk(func(entry, path string, info os.FileInfo, reason string) error {
+		e := &Entry{
+			Path:   filepath.ToSlash(entry),
+			Dir:    info.IsDir(),
+			Reason: reason,
+		}
+		if !e.Dir {
+			e.Size = info.Size()
+		}
+
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
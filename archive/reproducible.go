@@ -0,0 +1,71 @@
+package archive
+
+import (
+	"archive/tar"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// SourceDateEpochEnvVar is the environment variable with the modification
+// time, in seconds since the Unix epoch, of the entries of a reproducible
+// archive. See https://reproducible-builds.org/specs/source-date-epoch/.
+const SourceDateEpochEnvVar = "SOURCE_DATE_EPOCH"
+
+// headerFunc returns the function that normalizes the header of every entry
+// of a reproducible archive, or nil if the archive need not be reproducible.
+//
+// In a reproducible archive everything that depends on the machine or the
+// checkout rather than on the files themselves is the same for every entry:
+// the modification time, the owner (root, without user and group names) and
+// the permissions, which are 0755 for directories and executables and 0644
+// for other files. The order of the entries is always deterministic, and
+// so is the header of the compressed stream, since it never holds a name or
+// timestamp.
+func headerFunc(opts *ArchiveOpts) (func(*tar.Header), error) {
+	if !opts.Reproducible {
+		return nil, nil
+	}
+
+	modTime, err := reproducibleModTime(opts.ModTime)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(h *tar.Header) {
+		h.ModTime = modTime
+		h.AccessTime = time.Time{}
+		h.ChangeTime = time.Time{}
+		h.Uid, h.Gid = 0, 0
+		h.Uname, h.Gname = "", ""
+
+		if h.Typeflag == tar.TypeDir || h.Mode&0111 != 0 {
+			h.Mode = 0755
+		} else {
+			h.Mode = 0644
+		}
+	}, nil
+}
+
+// reproducibleModTime returns the modification time for the entries of a
+// reproducible archive: the given time if it is set, else the time from the
+// SOURCE_DATE_EPOCH environment variable, else the Unix epoch.
+func reproducibleModTime(modTime time.Time) (time.Time, error) {
+	if !modTime.IsZero() {
+		return modTime.UTC().Truncate(time.Second), nil
+	}
+
+	if v := os.Getenv(SourceDateEpochEnvVar); v != "" {
+		epoch, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || epoch < 0 {
+			return time.Time{}, fmt.Errorf(
+				"invalid %s %q: must be a number of seconds",
+				SourceDateEpochEnvVar, v)
+		}
+
+		return time.Unix(epoch, 0).UTC(), nil
+	}
+
+	return time.Unix(0, 0).UTC(), nil
+}
@@ -0,0 +1,182 @@
+package archive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCreateArchive_reproducible(t *testing.T) {
+	dir := testReproducibleDir(t)
+	defer os.RemoveAll(dir)
+
+	opts := &ArchiveOpts{Reproducible: true}
+	sum := testArchiveSum(t, dir, opts)
+
+	// Touch and chmod everything, which must not change the archive
+	later := time.Now().Add(time.Hour)
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			os.Chmod(path, 0600)
+		}
+		return os.Chtimes(path, later, later)
+	})
+	os.Chmod(filepath.Join(dir, "run.sh"), 0700)
+
+	if actual := testArchiveSum(t, dir, opts); actual != sum {
+		t.Fatalf("expected archive to be the same, got %s and %s", sum, actual)
+	}
+
+	// Without -reproducible the times are kept, so it does change
+	if testArchiveSum(t, dir, &ArchiveOpts{}) == sum {
+		t.Fatal("expected archive to differ without Reproducible")
+	}
+}
+
+func TestCreateArchive_reproducibleHeaders(t *testing.T) {
+	dir := testReproducibleDir(t)
+	defer os.RemoveAll(dir)
+
+	modTime := time.Date(2016, 9, 1, 12, 0, 0, 0, time.UTC)
+	headers := testArchiveHeaders(t, dir, &ArchiveOpts{
+		Reproducible: true,
+		ModTime:      modTime,
+		Extra:        map[string]string{"VERSION": filepath.Join(dir, "a.txt")},
+	})
+
+	expected := []struct {
+		Name string
+		Mode int64
+	}{
+		{"a.txt", 0644},
+		{"run.sh", 0755},
+		{"sub/", 0755},
+		{"sub/b.txt", 0644},
+		{"VERSION", 0644},
+	}
+	if len(headers) != len(expected) {
+		t.Fatalf("expected %d entries, got %d", len(expected), len(headers))
+	}
+
+	for i, h := range headers {
+		if h.Name != expected[i].Name {
+			t.Fatalf("expected entry %d to be %q, got %q", i, expected[i].Name, h.Name)
+		}
+		if h.Mode != expected[i].Mode {
+			t.Errorf("%s: expected mode %o, got %o", h.Name, expected[i].Mode, h.Mode)
+		}
+		if !h.ModTime.Equal(modTime) {
+			t.Errorf("%s: expected mtime %s, got %s", h.Name, modTime, h.ModTime)
+		}
+		if h.Uid != 0 || h.Gid != 0 || h.Uname != "" || h.Gname != "" {
+			t.Errorf("%s: expected root ownership, got %d:%d (%s:%s)",
+				h.Name, h.Uid, h.Gid, h.Uname, h.Gname)
+		}
+	}
+}
+
+func TestReproducibleModTime(t *testing.T) {
+	defer os.Setenv(SourceDateEpochEnvVar, os.Getenv(SourceDateEpochEnvVar))
+
+	os.Setenv(SourceDateEpochEnvVar, "")
+	if mt, err := reproducibleModTime(time.Time{}); err != nil || mt.Unix() != 0 {
+		t.Fatalf("expected the Unix epoch, got %s (%v)", mt, err)
+	}
+
+	os.Setenv(SourceDateEpochEnvVar, "1472731200")
+	if mt, err := reproducibleModTime(time.Time{}); err != nil || mt.Unix() != 1472731200 {
+		t.Fatalf("expected SOURCE_DATE_EPOCH, got %s (%v)", mt, err)
+	}
+
+	fixed := time.Unix(1000, 500)
+	if mt, err := reproducibleModTime(fixed); err != nil || mt.Unix() != 1000 || mt.Nanosecond() != 0 {
+		t.Fatalf("expected the given time, got %s (%v)", mt, err)
+	}
+
+	os.Setenv(SourceDateEpochEnvVar, "yesterday")
+	if _, err := reproducibleModTime(time.Time{}); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+// testReproducibleDir creates a directory with a few files to archive.
+func testReproducibleDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	files := map[string]os.FileMode{
+		"a.txt":     0644,
+		"run.sh":    0755,
+		"sub/b.txt": 0664,
+	}
+	for name, mode := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if err := ioutil.WriteFile(path, []byte(name), mode); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	return dir
+}
+
+func testArchiveSum(t *testing.T, dir string, opts *ArchiveOpts) string {
+	r, err := CreateArchive(dir, opts)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer r.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func testArchiveHeaders(t *testing.T, dir string, opts *ArchiveOpts) []*tar.Header {
+	r, err := CreateArchive(dir, opts)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer r.Close()
+
+	gzipR, err := gzip.NewReader(r)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !gzipR.ModTime.IsZero() || gzipR.Name != "" {
+		t.Fatalf("expected an empty gzip header, got %#v", gzipR.Header)
+	}
+
+	var headers []*tar.Header
+	tarR := tar.NewReader(gzipR)
+	for {
+		hdr, err := tarR.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		headers = append(headers, hdr)
+	}
+
+	return headers
+}
@@ -0,0 +1,95 @@
+package archive
+
+import (
+	"context"
+	"io"
+	"log"
+	"path/filepath"
+)
+
+// Stream is an archive that is written to an io.Writer on demand, instead
+// of being buffered in a temporary file like CreateArchive does. It can be
+// written any number of times, for example to learn its size before sending
+// it, and writes the same bytes every time as long as the files don't change.
+type Stream struct {
+	// Metadata is the metadata that was detected by the VCS, like
+	// Archive.Metadata. It is known before the archive is written.
+	Metadata map[string]string
+
+	// dir writes the archive of a directory, and passthrough is the path
+	// of an archive in the given format that is written as-is. validation
+	// is the result of its validation, if it was validated.
+	dir         *dirArchiver
+	passthrough string
+	format      string
+	validation  *validation
+}
+
+// NewStream returns the stream of the archive of the given path, with the
+// same rules as CreateArchive. Nothing is archived until the stream is
+// written, but the path and the options are checked, and a path that is an
+// archive is validated. The TempDir option is not used.
+func NewStream(path string, opts *ArchiveOpts) (*Stream, error) {
+	return NewStreamContext(context.Background(), path, opts)
+}
+
+// NewStreamContext is like NewStream, but stops validating an archive when
+// the context is done, and then returns the error of the context.
+func NewStreamContext(ctx context.Context, path string, opts *ArchiveOpts) (*Stream, error) {
+	log.Printf("[INFO] creating archive stream from %s", path)
+
+	path, fi, err := resolvePath(path, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if !fi.IsDir() {
+		format, err := passthroughFormat(path, opts.Format)
+		if err != nil {
+			return nil, err
+		}
+		if format != "" {
+			s := &Stream{passthrough: path, format: format}
+			if !opts.NoValidation {
+				s.validation, err = validateArchive(ctx, path, format)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			return s, nil
+		}
+
+		// Act like we're archiving a directory, but only include this one
+		// file.
+		path, err = filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		path, opts = filepath.Dir(path), fileOpts(path, opts)
+	}
+
+	d, err := newDirArchiver(path, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Stream{Metadata: d.metadata, dir: d}, nil
+}
+
+// WriteContext writes the archive to w and returns its description, which
+// has no data: its ReadCloser is nil. Writing stops when the context is
+// done, and the error of the context is returned.
+func (s *Stream) WriteContext(ctx context.Context, w io.Writer) (*Archive, error) {
+	if s.passthrough != "" {
+		a, err := copyArchive(ctx, s.passthrough, s.format, w)
+		if err != nil {
+			return nil, err
+		}
+
+		a.setValidation(s.validation)
+		return a, nil
+	}
+
+	return s.dir.write(ctx, w)
+}
@@ -0,0 +1,201 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStream(t *testing.T) {
+	dir := testReproducibleDir(t)
+	defer os.RemoveAll(dir)
+
+	s, err := NewStream(dir, &ArchiveOpts{})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// The stream writes the same archive every time
+	var first, second bytes.Buffer
+	a, err := s.WriteContext(context.Background(), &first)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := s.WriteContext(context.Background(), &second); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Fatal("expected both writes to be the same")
+	}
+
+	if a.ReadCloser != nil {
+		t.Fatal("expected the archive to have no data")
+	}
+	if a.Size != int64(first.Len()) {
+		t.Fatalf("expected %d to be %d", a.Size, first.Len())
+	}
+	sum := sha256.Sum256(first.Bytes())
+	if expected := hex.EncodeToString(sum[:]); a.SHA256 != expected {
+		t.Fatalf("expected %q to be %q", a.SHA256, expected)
+	}
+
+	// And the same archive as CreateArchive
+	r, err := CreateArchive(dir, &ArchiveOpts{})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer r.Close()
+
+	if r.SHA256 != a.SHA256 {
+		t.Fatalf("expected %q to be %q", a.SHA256, r.SHA256)
+	}
+}
+
+func TestStream_file(t *testing.T) {
+	s, err := NewStream(testFixture("archive-subdir/README.md"), &ArchiveOpts{})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := s.WriteContext(context.Background(), &buf); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	entries := testArchiveEntries(t, &buf)
+	if expected := []string{"README.md"}; !reflect.DeepEqual(entries, expected) {
+		t.Fatalf("expected %#v to be %#v", entries, expected)
+	}
+}
+
+func TestStream_gzip(t *testing.T) {
+	r, err := CreateArchive(testFixture("archive-subdir"), &ArchiveOpts{})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer r.Close()
+
+	// A gzipped file is written as it is
+	s, err := NewStream(r.ReadCloser.(*readCloseRemover).F.Name(), &ArchiveOpts{})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var buf bytes.Buffer
+	a, err := s.WriteContext(context.Background(), &buf)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if a.SHA256 != r.SHA256 {
+		t.Fatalf("expected %q to be %q", a.SHA256, r.SHA256)
+	}
+	if a.Size != r.Size || int64(buf.Len()) != r.Size {
+		t.Fatalf("expected %d and %d to be %d", a.Size, buf.Len(), r.Size)
+	}
+}
+
+func TestStream_canceled(t *testing.T) {
+	s, err := NewStream(testFixture("archive-subdir"), &ArchiveOpts{})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := s.WriteContext(ctx, ioutil.Discard); err != context.Canceled {
+		t.Fatalf("expected %v to be %v", err, context.Canceled)
+	}
+}
+
+func TestCreateArchive_tempDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := CreateArchive(testFixture("archive-subdir"), &ArchiveOpts{TempDir: dir})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer r.Close()
+
+	name := r.ReadCloser.(*readCloseRemover).F.Name()
+	if filepath.Dir(name) != dir {
+		t.Fatalf("expected %s to be in %s", name, dir)
+	}
+}
+
+func TestCreateArchive_freeSpaceWarning(t *testing.T) {
+	defer func(f func(string) (int64, error)) { freeSpace = f }(freeSpace)
+	defer log.SetOutput(os.Stderr)
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+
+	// The files don't fit uncompressed, but the archive is still written
+	freeSpace = func(string) (int64, error) { return 10, nil }
+	r, err := CreateArchive(testFixture("archive-subdir"), new(ArchiveOpts))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	r.Close()
+	if !strings.Contains(buf.String(), "[WARN]") ||
+		!strings.Contains(buf.String(), "only 10 bytes are free") {
+		t.Fatalf("expected a warning about the free space, got %q", buf.String())
+	}
+
+	// There is no warning if they fit
+	buf.Reset()
+	freeSpace = func(string) (int64, error) { return 1 << 30, nil }
+	r, err = CreateArchive(testFixture("archive-subdir"), new(ArchiveOpts))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	r.Close()
+	if strings.Contains(buf.String(), "[WARN]") {
+		t.Fatalf("expected no warning, got %q", buf.String())
+	}
+}
+
+func TestSpaceWriter(t *testing.T) {
+	defer func(f func(string) (int64, error)) { freeSpace = f }(freeSpace)
+
+	// A write that fails on a full disk says so
+	freeSpace = func(string) (int64, error) { return 10, nil }
+	w := &spaceWriter{w: &failingWriter{n: 1}, dir: "/tmp/full"}
+	if _, err := w.Write(make([]byte, 100)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	_, err := w.Write(make([]byte, 100))
+	if err == nil || !strings.Contains(err.Error(), "not enough free space in /tmp/full") ||
+		!strings.Contains(err.Error(), "100 bytes were written and only 10 bytes are free") {
+		t.Fatalf("expected an error about the free space, got %v", err)
+	}
+
+	// Any other failure is returned as-is
+	freeSpace = func(string) (int64, error) { return 1 << 30, nil }
+	w = &spaceWriter{w: &failingWriter{}, dir: "/tmp/full"}
+	if _, err := w.Write(make([]byte, 100)); err != io.ErrShortWrite {
+		t.Fatalf("expected %v to be %v", err, io.ErrShortWrite)
+	}
+
+	// So is a failure when the free space is unknown
+	freeSpace = func(string) (int64, error) { return 0, fmt.Errorf("not supported") }
+	w = &spaceWriter{w: &failingWriter{}, dir: "/tmp/full"}
+	if _, err := w.Write(make([]byte, 100)); err != io.ErrShortWrite {
+		t.Fatalf("expected %v to be %v", err, io.ErrShortWrite)
+	}
+}
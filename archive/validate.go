@@ -0,0 +1,163 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// validation is the result of validating an archive that is passed through
+// as-is.
+type validation struct {
+	entries int
+	size    int64
+}
+
+// validateArchive reads the archive in the given format at the given path
+// to the end, to check that it is complete and well-formed before it is
+// uploaded as-is: the data must decompress, the archive must parse and have
+// at least one entry, and every entry must stay inside the directory that
+// the archive is extracted to.
+func validateArchive(ctx context.Context, path, format string) (*validation, error) {
+	log.Printf("[INFO] validating %s archive %s", format, path)
+
+	var v *validation
+	var err error
+	if format == FormatZip {
+		v, err = validateZip(ctx, path)
+	} else {
+		v, err = validateTar(ctx, path, format)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		return nil, fmt.Errorf("invalid %s archive %s: %s", format, path, err)
+	}
+	if v.entries == 0 {
+		return nil, fmt.Errorf("invalid %s archive %s: it has no entries", format, path)
+	}
+
+	log.Printf("[INFO] validated %s: %d entries, %d bytes uncompressed",
+		path, v.entries, v.size)
+	return v, nil
+}
+
+func validateTar(ctx context.Context, path, format string) (*validation, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = &contextReader{ctx: ctx, r: f}
+	switch format {
+	case FormatTarGzip:
+		r, err = gzip.NewReader(r)
+	case FormatTarZstd:
+		var d *zstd.Decoder
+		d, err = zstd.NewReader(r)
+		if err == nil {
+			defer d.Close()
+			r = d
+		}
+	case FormatTarXz:
+		r, err = xz.NewReader(r)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	v := &validation{}
+	tarR := tar.NewReader(r)
+	for {
+		header, err := tarR.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if err := checkEntryName(header.Name); err != nil {
+			return nil, err
+		}
+
+		n, err := io.Copy(ioutil.Discard, tarR)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", header.Name, err)
+		}
+
+		v.entries++
+		v.size += n
+	}
+
+	// Whatever follows the end of the tar archive must decompress too, so
+	// that a truncated or corrupt end of the stream is noticed
+	if _, err := io.Copy(ioutil.Discard, r); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+func validateZip(ctx context.Context, path string) (*validation, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	v := &validation{}
+	for _, f := range zr.File {
+		if err := checkEntryName(f.Name); err != nil {
+			return nil, err
+		}
+
+		// The checksum of an entry is checked once it was read to the end
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", f.Name, err)
+		}
+		n, err := io.Copy(ioutil.Discard, &contextReader{ctx: ctx, r: rc})
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", f.Name, err)
+		}
+
+		v.entries++
+		v.size += n
+	}
+
+	return v, nil
+}
+
+// checkEntryName checks that an entry with the given name is extracted
+// inside the directory that the archive is extracted to: it must not be an
+// absolute path or go up with "..".
+func checkEntryName(name string) error {
+	slashed := strings.Replace(name, `\`, "/", -1)
+	if path.IsAbs(slashed) || (len(slashed) > 1 && slashed[1] == ':') {
+		return fmt.Errorf("entry %q has an absolute path", name)
+	}
+
+	for _, part := range strings.Split(slashed, "/") {
+		if part == ".." {
+			return fmt.Errorf("entry %q is outside of the archive", name)
+		}
+	}
+
+	return nil
+}
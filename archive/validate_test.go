@@ -0,0 +1,172 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testTarGzip writes a gzipped tar archive with a file for each of the given
+// names, whose content is the name, and returns its data.
+func testTarGzip(t *testing.T, names ...string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tarW := tar.NewWriter(gz)
+	for _, name := range names {
+		header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(name)), Typeflag: tar.TypeReg}
+		if err := tarW.WriteHeader(header); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		tarW.Write([]byte(name))
+	}
+	if err := tarW.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	return buf.Bytes()
+}
+
+func testWriteArchive(t *testing.T, dir, name string, data []byte) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	return path
+}
+
+func TestCreateArchive_validation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := testWriteArchive(t, dir, "app.tar.gz", testTarGzip(t, "foo.txt", "bar/baz.txt"))
+	r, err := CreateArchive(path, &ArchiveOpts{})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer r.Close()
+
+	if r.Entries != 2 {
+		t.Fatalf("expected %d to be 2", r.Entries)
+	}
+	if expected := int64(len("foo.txt") + len("bar/baz.txt")); r.UncompressedSize != expected {
+		t.Fatalf("expected %d to be %d", r.UncompressedSize, expected)
+	}
+}
+
+func TestCreateArchive_validationInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	valid := testTarGzip(t, "foo.txt")
+
+	var notTar bytes.Buffer
+	gz := gzip.NewWriter(&notTar)
+	gz.Write(bytes.Repeat([]byte("not a tar archive\n"), 64))
+	gz.Close()
+
+	var zipBuf bytes.Buffer
+	zw := zip.NewWriter(&zipBuf)
+	w, _ := zw.Create("../evil.txt")
+	w.Write([]byte("evil"))
+	zw.Close()
+
+	cases := []struct {
+		Name  string
+		Data  []byte
+		Error string
+	}{
+		{"truncated.tar.gz", valid[:len(valid)-10], "unexpected EOF"},
+		{"not-tar.tar.gz", notTar.Bytes(), "invalid tar.gz archive"},
+		{"empty.tar.gz", testTarGzip(t), "it has no entries"},
+		{"parent.tar.gz", testTarGzip(t, "foo.txt", "../evil.txt"), "outside of the archive"},
+		{"absolute.tar.gz", testTarGzip(t, "/etc/evil"), "absolute path"},
+		{"parent.zip", zipBuf.Bytes(), "outside of the archive"},
+	}
+
+	for _, tc := range cases {
+		path := testWriteArchive(t, dir, tc.Name, tc.Data)
+
+		// A zip is only passed through if the archive is a zip too
+		_, err := CreateArchive(path, &ArchiveOpts{Format: FormatZip})
+		if err == nil || !strings.Contains(err.Error(), tc.Error) {
+			t.Fatalf("%s: expected an error with %q, got %v", tc.Name, tc.Error, err)
+		}
+
+		// Without validation, the archive is passed through as-is
+		r, err := CreateArchive(path, &ArchiveOpts{Format: FormatZip, NoValidation: true})
+		if err != nil {
+			t.Fatalf("%s: err: %s", tc.Name, err)
+		}
+		data, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatalf("%s: err: %s", tc.Name, err)
+		}
+		if !bytes.Equal(data, tc.Data) {
+			t.Fatalf("%s: expected the archive to be passed through", tc.Name)
+		}
+		if r.Entries != 0 {
+			t.Fatalf("%s: expected %d to be 0", tc.Name, r.Entries)
+		}
+	}
+}
+
+func TestNewStream_validation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := testWriteArchive(t, dir, "evil.tar.gz", testTarGzip(t, "../evil.txt"))
+	if _, err := NewStream(path, &ArchiveOpts{}); err == nil {
+		t.Fatal("expected an error")
+	}
+	if _, err := NewStream(path, &ArchiveOpts{NoValidation: true}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestCheckEntryName(t *testing.T) {
+	cases := []struct {
+		Name  string
+		Valid bool
+	}{
+		{"foo.txt", true},
+		{"foo/bar.txt", true},
+		{"./foo/", true},
+		{"foo..bar", true},
+		{"..foo/bar", true},
+		{"..", false},
+		{"../foo", false},
+		{"foo/../../bar", false},
+		{`foo\..\bar`, false},
+		{"/etc/passwd", false},
+		{`\Windows\evil`, false},
+		{`C:\evil`, false},
+		{"C:evil", false},
+	}
+
+	for _, tc := range cases {
+		err := checkEntryName(tc.Name)
+		if (err == nil) != tc.Valid {
+			t.Fatalf("%q: expected valid to be %t, got %v", tc.Name, tc.Valid, err)
+		}
+	}
+}
@@ -1,16 +1,18 @@
 package main
 
 import (
-	"flag"
 	"fmt"
 	"io"
 	"log"
+	"net"
+	"net/url"
 	"os"
+	"sort"
 	"strings"
 
-	"github.com/hashicorp/atlas-go/archive"
+	"github.com/hashicorp/atlas-go/v1"
+	"github.com/hashicorp/atlas-upload-cli/upload"
 	"github.com/hashicorp/logutils"
-	"github.com/mitchellh/ioprogress"
 )
 
 // Exit codes are int values that represent an exit code for a particular error.
@@ -25,18 +27,75 @@ const (
 	ExitCodeBadArgs
 	ExitCodeArchiveError
 	ExitCodeUploadError
+	ExitCodeInterrupted
+
+	// Errors of requests to Atlas that CI may want to handle differently:
+	// the token is invalid, the resource doesn't exist or is not visible to
+	// the token, the server rejected the request, or it couldn't be reached.
+	ExitCodeAuthError
+	ExitCodeNotFoundError
+	ExitCodeValidationError
+	ExitCodeNetworkError
 )
 
+// requestExitCode returns the exit code for the error of a request to Atlas.
+// Authentication failures, missing resources, validation errors and network
+// errors have their own exit codes; other errors get the given code.
+func requestExitCode(err error, code int) int {
+	if e, ok := err.(*upload.Error); ok {
+		err = e.Err
+	}
+
+	switch err {
+	case atlas.ErrAuth:
+		return ExitCodeAuthError
+	case atlas.ErrNotFound:
+		return ExitCodeNotFoundError
+	}
+
+	switch err.(type) {
+	case *atlas.RailsError:
+		return ExitCodeValidationError
+	case *url.Error, net.Error:
+		return ExitCodeNetworkError
+	}
+
+	return code
+}
+
 // levelFilter is the log filter with pre-defined levels
 var levelFilter = &logutils.LevelFilter{
 	Levels: []logutils.LogLevel{"DEBUG", "INFO", "WARN", "ERR"},
 }
 
+// Command is a single subcommand of the CLI, such as "upload" or "version".
+type Command interface {
+	// Help returns the long-form help text for the command, including the
+	// usage line and all of its options.
+	Help() string
+
+	// Synopsis returns a one-line description of the command that is shown
+	// in the list of available commands.
+	Synopsis() string
+
+	// Run runs the command with the given arguments, which do not include
+	// the name of the command, and returns the exit code.
+	Run(args []string) int
+}
+
+// defaultCommand is the command that is run when the first argument is not
+// the name of a command. This keeps "atlas-upload [options] slug path"
+// working as it always has.
+const defaultCommand = "upload"
+
 // CLI is the command line object
 type CLI struct {
 	// outStream and errStream are the standard out and standard error streams to
 	// write messages from the CLI.
 	outStream, errStream io.Writer
+
+	// inStream is the standard input, which prompts are read from.
+	inStream io.Reader
 }
 
 // Run invokes the CLI with the given arguments. The first argument is always
@@ -45,98 +104,92 @@ func (cli *CLI) Run(args []string) int {
 	// Initialize the logger to start (overridden later if debug is given)
 	cli.initLogger(os.Getenv("ATLAS_LOG"))
 
-	var debug, version bool
-	var archiveOpts archive.ArchiveOpts
-	var uploadOpts UploadOpts
+	args = args[1:]
+	commands := cli.commands()
 
-	flags := flag.NewFlagSet(Name, flag.ContinueOnError)
-	flags.SetOutput(cli.errStream)
-	flags.Usage = func() {
-		fmt.Fprintf(cli.errStream, usage, Name)
-	}
-	flags.BoolVar(&archiveOpts.VCS, "vcs", false,
-		"Uses VCS to determine files to exclude and include")
-	flags.StringVar(&uploadOpts.URL, "address", "",
-		"Atlas server address")
-	flags.StringVar(&uploadOpts.Token, "token", "",
-		"Atlas API token")
-	flags.Var((*FlagSliceVar)(&archiveOpts.Exclude), "exclude",
-		"files/folders to exclude")
-	flags.Var((*FlagSliceVar)(&archiveOpts.Include), "include",
-		"files/folders to include")
-	flags.Var((*FlagMetadataVar)(&uploadOpts.Metadata), "metadata",
-		"arbitrary metadata to pass along with the request")
-	flags.BoolVar(&debug, "debug", false,
-		"turn on debug output")
-	flags.BoolVar(&version, "version", false,
-		"display the version")
-
-	// Parse all the flags
-	if err := flags.Parse(args[1:]); err != nil {
-		return ExitCodeParseFlagsError
+	if len(args) == 0 {
+		fmt.Fprint(cli.errStream, cli.help(commands))
+		return ExitCodeBadArgs
 	}
 
-	// Turn on debug mode if requested
-	if debug {
-		levelFilter.SetMinLevel(logutils.LogLevel("DEBUG"))
+	switch args[0] {
+	case "-h", "-help", "--help":
+		fmt.Fprint(cli.errStream, cli.help(commands))
+		return ExitCodeOK
+	case "help":
+		return cli.runHelp(commands, args[1:])
+	case "-v", "-version", "--version":
+		return commands["version"].Run(args[1:])
 	}
 
-	// Version
-	if version {
-		fmt.Fprintf(cli.errStream, "%s v%s\n", Name, Version)
-		return ExitCodeOK
+	// If the first argument is not a command, it is either a flag or the
+	// slug, so fall back to the default command.
+	command, ok := commands[args[0]]
+	if !ok {
+		return commands[defaultCommand].Run(args)
 	}
 
-	// Get the parsed arguments (the ones left over after all the flags have been
-	// parsed)
-	parsedArgs := flags.Args()
+	return command.Run(args[1:])
+}
 
-	if len(parsedArgs) != 2 {
-		fmt.Fprintf(cli.errStream, "cli: must specify two arguments - slug, path\n")
-		flags.Usage()
-		return ExitCodeBadArgs
+// commands returns all of the available commands, keyed by name.
+func (cli *CLI) commands() map[string]Command {
+	meta := Meta{
+		outStream: cli.outStream,
+		errStream: cli.errStream,
+		inStream:  cli.inStream,
 	}
 
-	// Get the name of the app and the path to archive
-	slug, path := parsedArgs[0], parsedArgs[1]
-	uploadOpts.Slug = slug
+	return map[string]Command{
+		"archive":      &ArchiveCommand{Meta: meta},
+		"artifact":     &ArtifactCommand{Meta: meta},
+		"build-config": &BuildConfigCommand{Meta: meta},
+		"login":        &LoginCommand{Meta: meta},
+		"terraform":    &TerraformCommand{Meta: meta},
+		"upload":       &UploadCommand{Meta: meta},
+		"verify":       &VerifyCommand{Meta: meta},
+		"version":      &VersionCommand{Meta: meta},
+	}
+}
 
-	// Get the archive reader
-	r, err := archive.CreateArchive(path, &archiveOpts)
-	if err != nil {
-		fmt.Fprintf(cli.errStream, "error archiving: %s\n", err)
-		return ExitCodeArchiveError
+// runHelp prints the help for the given command, or the list of commands if
+// no command was given.
+func (cli *CLI) runHelp(commands map[string]Command, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(cli.errStream, cli.help(commands))
+		return ExitCodeOK
 	}
-	defer r.Close()
-
-	// Put a progress bar around the reader
-	pr := &ioprogress.Reader{
-		Reader: r,
-		Size:   r.Size,
-		DrawFunc: ioprogress.DrawTerminalf(os.Stdout, func(p, t int64) string {
-			return fmt.Sprintf(
-				"Uploading %s: %s",
-				slug,
-				ioprogress.DrawTextFormatBytes(p, t))
-		}),
+
+	command, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(cli.errStream, "cli: unknown command %q\n", args[0])
+		fmt.Fprint(cli.errStream, cli.help(commands))
+		return ExitCodeBadArgs
 	}
 
-	// Start the upload
-	doneCh, uploadErrCh, err := Upload(pr, r.Size, &uploadOpts)
-	if err != nil {
-		fmt.Fprintf(cli.errStream, "error starting upload: %s\n", err)
-		return ExitCodeUploadError
+	fmt.Fprintf(cli.errStream, "%s\n", strings.TrimSpace(command.Help()))
+	return ExitCodeOK
+}
+
+// help returns the top-level help text, which lists every available command.
+func (cli *CLI) help(commands map[string]Command) string {
+	names := make([]string, 0, len(commands))
+	maxLen := 0
+	for name := range commands {
+		names = append(names, name)
+		if len(name) > maxLen {
+			maxLen = len(name)
+		}
 	}
+	sort.Strings(names)
 
-	select {
-	case err := <-uploadErrCh:
-		fmt.Fprintf(cli.errStream, "error uploading: %s\n", err)
-		return ExitCodeUploadError
-	case version := <-doneCh:
-		fmt.Printf("Uploaded %s v%d\n", slug, version)
+	var list []string
+	for _, name := range names {
+		list = append(list, fmt.Sprintf("    %-*s    %s",
+			maxLen, name, commands[name].Synopsis()))
 	}
 
-	return ExitCodeOK
+	return fmt.Sprintf(usage, Name, Name, Name, strings.Join(list, "\n"))
 }
 
 // initLogger gets the log level from the environment, falling back to DEBUG if
@@ -147,41 +200,25 @@ func (cli *CLI) initLogger(level string) {
 		minLevel = "WARN"
 	}
 
-	levelFilter.Writer = cli.errStream
+	// Everything that is logged goes through the redactor, so that tokens
+	// don't end up in CI logs with -debug
+	logRedactor.w = cli.errStream
+	levelFilter.Writer = logRedactor
 	levelFilter.SetMinLevel(logutils.LogLevel(level))
 	log.SetOutput(levelFilter)
 }
 
 const usage = `
-Usage: %s [options] slug path
+Usage: %s [--version] [--help] <command> [<args>]
+       %s [options] slug path
 
   Upload application code or artifacts to Atlas for initiating deployments.
 
-  "slug" is the name of the <username>/<application_name> to upload to within Atlas.
-
-  If path is a directory, it will be compressed (gzip tar) and uploaded
-  in its entirety. The root of the archive will be the path. For clarity:
-  if you upload the "foo/" directory, then the file "foo/version" will be
-  "version" in the archive since "foo/" is the root.
-
-  A path must be specified. Due to the nature of this application, it does
-  not default to using the current working directory automatically.
-
-Options:
-
-  -exclude=<path>     Glob pattern of files or directories to exclude (this may
-                      be specified multiple times)
-  -include=<path>     Glob pattern of files/directories to include (this may be
-                      specified multiple times, any excludes will override
-                      conflicting includes)
-  -address=<url>      The address of the Atlas server
-  -token=<token>      The Atlas API token
-  -vcs                Get lists of files to exclude and include from a version
-                      control system (Git, Mercurial or Subversion)
+  When the first argument is not a command, the "upload" command is run, so
+  "%s [options] slug path" uploads the path to the given application.
 
-  -metadata<k=v>      Arbitrary key-value (string) metadata to be sent with the
-                      upload; may be specified multiple times
+  Run "help <command>" to get the full help text of a command.
 
-  -debug              Turn on debug output
-  -version            Print the version of this application
+Available commands are:
+%s
 `
@@ -2,9 +2,15 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/url"
 	"strings"
 	"testing"
+
+	"github.com/hashicorp/atlas-go/v1"
+	"github.com/hashicorp/atlas-upload-cli/upload"
 )
 
 func TestRun__versionFlag(t *testing.T) {
@@ -38,3 +44,113 @@ func TestRun_parseError(t *testing.T) {
 		t.Fatalf("expected %q to contain %q", errStream.String(), expected)
 	}
 }
+
+func TestRun_versionCommand(t *testing.T) {
+	outStream, errStream := new(bytes.Buffer), new(bytes.Buffer)
+	cli := &CLI{outStream: outStream, errStream: errStream}
+	args := strings.Split("atlas-upload version", " ")
+
+	status := cli.Run(args)
+	if status != ExitCodeOK {
+		t.Errorf("expected %d to eq %d", status, ExitCodeOK)
+	}
+
+	expected := fmt.Sprintf("atlas-upload v%s", Version)
+	if !strings.Contains(errStream.String(), expected) {
+		t.Errorf("expected %q to eq %q", errStream.String(), expected)
+	}
+}
+
+func TestRun_noArgs(t *testing.T) {
+	outStream, errStream := new(bytes.Buffer), new(bytes.Buffer)
+	cli := &CLI{outStream: outStream, errStream: errStream}
+
+	status := cli.Run([]string{"atlas-upload"})
+	if status != ExitCodeBadArgs {
+		t.Errorf("expected %d to eq %d", status, ExitCodeBadArgs)
+	}
+
+	expected := "Available commands are:"
+	if !strings.Contains(errStream.String(), expected) {
+		t.Errorf("expected %q to contain %q", errStream.String(), expected)
+	}
+}
+
+func TestRun_helpCommand(t *testing.T) {
+	outStream, errStream := new(bytes.Buffer), new(bytes.Buffer)
+	cli := &CLI{outStream: outStream, errStream: errStream}
+	args := strings.Split("atlas-upload help verify", " ")
+
+	status := cli.Run(args)
+	if status != ExitCodeOK {
+		t.Errorf("expected %d to eq %d", status, ExitCodeOK)
+	}
+
+	expected := "Usage: atlas-upload verify"
+	if !strings.Contains(errStream.String(), expected) {
+		t.Errorf("expected %q to contain %q", errStream.String(), expected)
+	}
+}
+
+func TestRun_defaultCommand(t *testing.T) {
+	outStream, errStream := new(bytes.Buffer), new(bytes.Buffer)
+	cli := &CLI{outStream: outStream, errStream: errStream}
+	args := strings.Split("atlas-upload hashicorp/project", " ")
+
+	status := cli.Run(args)
+	if status != ExitCodeBadArgs {
+		t.Errorf("expected %d to eq %d", status, ExitCodeBadArgs)
+	}
+
+	expected := "Usage: atlas-upload upload"
+	if !strings.Contains(errStream.String(), expected) {
+		t.Errorf("expected %q to contain %q", errStream.String(), expected)
+	}
+}
+
+func TestRun_jsonError(t *testing.T) {
+	outStream, errStream := new(bytes.Buffer), new(bytes.Buffer)
+	cli := &CLI{outStream: outStream, errStream: errStream}
+	args := strings.Split("atlas-upload -format=json -verify=false hashicorp/project ./does-not-exist", " ")
+
+	status := cli.Run(args)
+	if status != ExitCodeArchiveError {
+		t.Fatalf("expected %d to eq %d", status, ExitCodeArchiveError)
+	}
+
+	var result errorResult
+	if err := json.Unmarshal(outStream.Bytes(), &result); err != nil {
+		t.Fatalf("expected %q to be JSON: %s", outStream.String(), err)
+	}
+
+	if result.ExitCode != ExitCodeArchiveError {
+		t.Errorf("expected %d to eq %d", result.ExitCode, ExitCodeArchiveError)
+	}
+	if result.Category != "archive" {
+		t.Errorf("expected %q to eq %q", result.Category, "archive")
+	}
+	if !strings.Contains(result.Error, "error archiving") {
+		t.Errorf("expected %q to contain %q", result.Error, "error archiving")
+	}
+}
+
+func TestRequestExitCode(t *testing.T) {
+	cases := []struct {
+		err  error
+		code int
+	}{
+		{atlas.ErrAuth, ExitCodeAuthError},
+		{&upload.Error{Err: atlas.ErrAuth}, ExitCodeAuthError},
+		{atlas.ErrNotFound, ExitCodeNotFoundError},
+		{&upload.Error{Err: atlas.ErrNotFound}, ExitCodeNotFoundError},
+		{&atlas.RailsError{Errors: []string{"name is invalid"}}, ExitCodeValidationError},
+		{&url.Error{Op: "Get", URL: "https://atlas.hashicorp.com", Err: errors.New("connection refused")}, ExitCodeNetworkError},
+		{errors.New("client: 500 Internal Server Error"), ExitCodeUploadError},
+	}
+
+	for _, tc := range cases {
+		if code := requestExitCode(tc.err, ExitCodeUploadError); code != tc.code {
+			t.Errorf("%q: expected %d to eq %d", tc.err, code, tc.code)
+		}
+	}
+}
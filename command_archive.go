@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/atlas-upload-cli/archive"
+)
+
+// ArchiveCommand is the command that archives a path exactly like the upload
+// command would, but writes the archive to a local file instead of uploading
+// it to Atlas.
+type ArchiveCommand struct {
+	Meta
+}
+
+func (c *ArchiveCommand) Run(args []string) int {
+	var output string
+	var archiveOpts archive.ArchiveOpts
+
+	flags := c.flagSet("archive", c.Help())
+	c.archiveFlags(flags, &archiveOpts)
+	c.archiveFormatFlags(flags, &archiveOpts)
+	c.configFlags(flags)
+	flags.StringVar(&output, "output", "",
+		"path to write the archive to, or - for stdout")
+
+	if err := c.parseFlags(flags, args); err != nil {
+		return ExitCodeParseFlagsError
+	}
+
+	parsedArgs := flags.Args()
+	if len(parsedArgs) != 1 {
+		fmt.Fprintf(c.errStream, "archive: must specify one argument - path\n")
+		flags.Usage()
+		return ExitCodeBadArgs
+	}
+
+	path := parsedArgs[0]
+	if _, err := c.loadConfig(flags, path, &archiveOpts); err != nil {
+		fmt.Fprintf(c.errStream, "%s\n", err)
+		return ExitCodeBadArgs
+	}
+
+	if !archive.ValidFormat(archiveOpts.Format) {
+		fmt.Fprintf(c.errStream, "archive: invalid archive format %q\n", archiveOpts.Format)
+		return ExitCodeBadArgs
+	}
+
+	// Only list the files for a dry run
+	if c.dryRun {
+		return c.listArchive(path, &archiveOpts)
+	}
+
+	if output == "" {
+		fmt.Fprintf(c.errStream, "archive: -output must be specified\n")
+		flags.Usage()
+		return ExitCodeBadArgs
+	}
+
+	// Cancel archiving and remove what was written when we are interrupted
+	ctx, cancel := c.interruptContext()
+	defer cancel()
+
+	r, err := archive.CreateArchiveContext(ctx, path, &archiveOpts)
+	if err != nil {
+		if ctx.Err() != nil {
+			return c.interrupted("archiving")
+		}
+
+		fmt.Fprintf(c.errStream, "error archiving: %s\n", err)
+		return ExitCodeArchiveError
+	}
+	defer r.Close()
+
+	// When the archive goes to stdout, the summary must not end up in the
+	// middle of it.
+	summaryStream := c.outStream
+	if output == "-" {
+		summaryStream = c.errStream
+		if err := c.copyArchive(c.outStream, r); err != nil {
+			fmt.Fprintf(c.errStream, "error writing archive: %s\n", err)
+			return ExitCodeArchiveError
+		}
+	} else {
+		if err := c.writeArchive(ctx, output, r); err != nil {
+			if ctx.Err() != nil {
+				return c.interrupted("writing the archive")
+			}
+
+			fmt.Fprintf(c.errStream, "error writing archive: %s\n", err)
+			return ExitCodeArchiveError
+		}
+	}
+
+	fmt.Fprintf(summaryStream, "Archived %s to %s (%d bytes)\n", path, output, r.Size)
+	if r.Entries > 0 {
+		fmt.Fprintf(summaryStream, "Entries: %d (%d bytes uncompressed)\n",
+			r.Entries, r.UncompressedSize)
+	}
+	return ExitCodeOK
+}
+
+// writeArchive writes the archive to the file at the given path. If writing
+// fails or the context is done, the partially written file is removed.
+func (c *ArchiveCommand) writeArchive(ctx context.Context, path string, r *archive.Archive) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	err = c.copyArchive(f, r)
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(path)
+		return err
+	}
+
+	return nil
+}
+
+// copyArchive copies the complete archive to the given writer.
+func (c *ArchiveCommand) copyArchive(w io.Writer, r *archive.Archive) error {
+	n, err := io.Copy(w, r)
+	if err != nil {
+		return err
+	}
+
+	if n != r.Size {
+		return fmt.Errorf("wrote %d bytes, expected %d", n, r.Size)
+	}
+
+	return nil
+}
+
+func (c *ArchiveCommand) Synopsis() string {
+	return "Writes the archive to a local file instead of uploading it"
+}
+
+func (c *ArchiveCommand) Help() string {
+	helpText := `
+Usage: %s archive [options] -output=<file> path
+       %s archive [options] -dry-run path
+
+  Archive the path exactly like the upload command does, but write the
+  archive to a local file instead of uploading it to Atlas. Nothing is sent
+  to Atlas.
+
+  The resulting file is a gzip tar, or an archive in the format given with
+  -archive-format, that can be uploaded later with "%s upload slug <file>"
+  (with -archive-format=zip for a zip, so that it is uploaded as-is).
+  Metadata detected by -vcs is not stored in the archive, so pass it along
+  with -metadata when uploading the file.
+
+Options:
+
+  -output=<file>      The path to write the archive to, or "-" to write it
+                      to stdout (required)
+` + archiveHelp + archiveFormatHelp + configHelp + `  -vcs                Get lists of files to exclude and include from a version
+                      control system (Git, Mercurial or Subversion)
+
+  -debug              Turn on debug output
+`
+	return strings.TrimSpace(fmt.Sprintf(helpText, Name, Name, Name))
+}
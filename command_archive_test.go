@@ -0,0 +1,238 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestArchiveCommand_output(t *testing.T) {
+	output := tempFile(t)
+	defer os.Remove(output)
+
+	outStream, errStream := new(bytes.Buffer), new(bytes.Buffer)
+	cli := &CLI{outStream: outStream, errStream: errStream}
+	args := []string{
+		"atlas-upload", "archive",
+		"-exclude=*.log",
+		"-output=" + output,
+		testFixture("archive-dir"),
+	}
+
+	if status := cli.Run(args); status != ExitCodeOK {
+		t.Fatalf("expected %d to eq %d: %s", status, ExitCodeOK, errStream.String())
+	}
+
+	f, err := os.Open(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	entries := tarEntries(t, f)
+	expected := []string{"foo.txt", "sub/", "sub/bar.txt"}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Fatalf("expected %#v to be %#v", entries, expected)
+	}
+}
+
+func TestArchiveCommand_stdout(t *testing.T) {
+	outStream, errStream := new(bytes.Buffer), new(bytes.Buffer)
+	cli := &CLI{outStream: outStream, errStream: errStream}
+	args := []string{
+		"atlas-upload", "archive",
+		"-include=foo.txt",
+		"-output=-",
+		testFixture("archive-dir"),
+	}
+
+	if status := cli.Run(args); status != ExitCodeOK {
+		t.Fatalf("expected %d to eq %d: %s", status, ExitCodeOK, errStream.String())
+	}
+
+	entries := tarEntries(t, outStream)
+	expected := []string{"foo.txt"}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Fatalf("expected %#v to be %#v", entries, expected)
+	}
+}
+
+func TestArchiveCommand_noOutput(t *testing.T) {
+	outStream, errStream := new(bytes.Buffer), new(bytes.Buffer)
+	cli := &CLI{outStream: outStream, errStream: errStream}
+	args := []string{"atlas-upload", "archive", testFixture("archive-dir")}
+
+	if status := cli.Run(args); status != ExitCodeBadArgs {
+		t.Fatalf("expected %d to eq %d", status, ExitCodeBadArgs)
+	}
+}
+
+func TestArchiveCommand_compressionLevel(t *testing.T) {
+	archive := func(level string) (int, []byte) {
+		outStream, errStream := new(bytes.Buffer), new(bytes.Buffer)
+		cli := &CLI{outStream: outStream, errStream: errStream}
+		args := []string{
+			"atlas-upload", "archive",
+			"-compression-level=" + level,
+			"-output=-",
+			testFixture("archive-dir"),
+		}
+
+		return cli.Run(args), outStream.Bytes()
+	}
+
+	status, stored := archive("0")
+	if status != ExitCodeOK {
+		t.Fatalf("expected %d to eq %d", status, ExitCodeOK)
+	}
+	status, best := archive("9")
+	if status != ExitCodeOK {
+		t.Fatalf("expected %d to eq %d", status, ExitCodeOK)
+	}
+
+	if len(stored) <= len(best) {
+		t.Fatalf("expected the stored archive to be larger, got %d and %d bytes",
+			len(stored), len(best))
+	}
+	if entries := tarEntries(t, bytes.NewReader(stored)); len(entries) != 4 {
+		t.Fatalf("expected 4 entries, got %#v", entries)
+	}
+
+	if status, _ := archive("10"); status != ExitCodeParseFlagsError {
+		t.Fatalf("expected %d to eq %d", status, ExitCodeParseFlagsError)
+	}
+}
+
+func TestArchiveCommand_archiveFormat(t *testing.T) {
+	outStream, errStream := new(bytes.Buffer), new(bytes.Buffer)
+	cli := &CLI{outStream: outStream, errStream: errStream}
+	args := []string{
+		"atlas-upload", "archive",
+		"-archive-format=zip",
+		"-output=-",
+		testFixture("archive-dir"),
+	}
+
+	if status := cli.Run(args); status != ExitCodeOK {
+		t.Fatalf("expected %d to eq %d: %s", status, ExitCodeOK, errStream.String())
+	}
+
+	r, err := zip.NewReader(bytes.NewReader(outStream.Bytes()), int64(outStream.Len()))
+	if err != nil {
+		t.Fatalf("expected a zip archive: %s", err)
+	}
+	if len(r.File) != 4 {
+		t.Fatalf("expected 4 entries, got %d", len(r.File))
+	}
+
+	args = []string{
+		"atlas-upload", "archive",
+		"-archive-format=rar",
+		"-output=-",
+		testFixture("archive-dir"),
+	}
+	if status := cli.Run(args); status != ExitCodeBadArgs {
+		t.Fatalf("expected %d to eq %d", status, ExitCodeBadArgs)
+	}
+}
+
+func TestArchiveCommand_validation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "atlas-upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A gzip stream that ends early is not a complete archive
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tarW := tar.NewWriter(gz)
+	tarW.WriteHeader(&tar.Header{Name: "foo.txt", Mode: 0644, Size: 3, Typeflag: tar.TypeReg})
+	tarW.Write([]byte("foo"))
+	tarW.Close()
+	gz.Close()
+
+	path := filepath.Join(dir, "app.tar.gz")
+	if err := ioutil.WriteFile(path, buf.Bytes()[:buf.Len()-10], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	outStream, errStream := new(bytes.Buffer), new(bytes.Buffer)
+	cli := &CLI{outStream: outStream, errStream: errStream}
+	args := []string{"atlas-upload", "archive", "-output=-", path}
+	if status := cli.Run(args); status != ExitCodeArchiveError {
+		t.Fatalf("expected %d to eq %d", status, ExitCodeArchiveError)
+	}
+	if !strings.Contains(errStream.String(), "invalid tar.gz archive") {
+		t.Fatalf("expected the archive to be invalid: %s", errStream.String())
+	}
+
+	outStream.Reset()
+	errStream.Reset()
+	args = []string{"atlas-upload", "archive", "-no-validation", "-output=-", path}
+	if status := cli.Run(args); status != ExitCodeOK {
+		t.Fatalf("expected %d to eq %d: %s", status, ExitCodeOK, errStream.String())
+	}
+	if outStream.Len() != buf.Len()-10 {
+		t.Fatalf("expected the archive to be written as-is, got %d bytes", outStream.Len())
+	}
+}
+
+// tarEntries returns the sorted names of all entries in the gzipped tar.
+func tarEntries(t *testing.T, r io.Reader) []string {
+	gzipR, err := gzip.NewReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var entries []string
+	tarR := tar.NewReader(gzipR)
+	for {
+		hdr, err := tarR.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		entries = append(entries, hdr.Name)
+	}
+
+	sort.Strings(entries)
+	return entries
+}
+
+func TestArchiveCommand_dryRun(t *testing.T) {
+	outStream, errStream := new(bytes.Buffer), new(bytes.Buffer)
+	cli := &CLI{outStream: outStream, errStream: errStream}
+	args := []string{
+		"atlas-upload", "archive",
+		"-dry-run", "-show-excluded",
+		"-exclude=*.log",
+		testFixture("archive-dir"),
+	}
+
+	if status := cli.Run(args); status != ExitCodeOK {
+		t.Fatalf("expected %d to eq %d: %s", status, ExitCodeOK, errStream.String())
+	}
+
+	expected := `- debug.log (matched exclude pattern "*.log")
++ foo.txt
++ sub/
++ sub/bar.txt
+2 files, 8 bytes
+`
+	if outStream.String() != expected {
+		t.Fatalf("expected %q to be %q", outStream.String(), expected)
+	}
+}
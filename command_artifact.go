@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/atlas-upload-cli/upload"
+)
+
+// ArtifactCommand is the command that uploads a file, or only an ID and
+// metadata, as a new version of an artifact in Atlas.
+type ArtifactCommand struct {
+	Meta
+}
+
+func (c *ArtifactCommand) Run(args []string) int {
+	var opts upload.ArtifactOpts
+	var metadata map[string]interface{}
+	var retryOpts upload.RetryOpts
+
+	flags := c.flagSet("artifact", c.Help())
+	c.clientFlags(flags)
+	c.verifyFlags(flags)
+	c.configFlags(flags)
+	c.retryFlags(flags, &retryOpts)
+	c.formatFlags(flags)
+	flags.StringVar(&opts.ID, "id", "",
+		"ID of the artifact within its type")
+	flags.IntVar(&opts.BuildID, "build-id", 0,
+		"ID of the build that produced the artifact")
+	flags.IntVar(&opts.CompileID, "compile-id", 0,
+		"ID of the compile that produced the artifact")
+	flags.Var((*FlagMetadataVar)(&metadata), "metadata",
+		"arbitrary metadata to pass along with the request")
+	flags.BoolVar(&opts.DigestHeaders, "digest-headers", false,
+		"send the checksum in the headers of the upload request")
+
+	if err := c.parseFlags(flags, args); err != nil {
+		return ExitCodeParseFlagsError
+	}
+
+	if !validFormat(c.format) {
+		return c.fail(ExitCodeBadArgs, "cli: invalid format %q", c.format)
+	}
+
+	parsedArgs := flags.Args()
+	if len(parsedArgs) < 2 || len(parsedArgs) > 3 {
+		code := c.fail(ExitCodeBadArgs, "cli: must specify a slug, a type and optionally a path")
+		flags.Usage()
+		return code
+	}
+	opts.Slug, opts.Type = parsedArgs[0], parsedArgs[1]
+
+	var path string
+	if len(parsedArgs) == 3 {
+		path = parsedArgs[2]
+	}
+	if _, err := c.loadConfig(flags, path, nil); err != nil {
+		return c.fail(ExitCodeBadArgs, "%s", err)
+	}
+
+	if path == "" && opts.ID == "" {
+		return c.fail(ExitCodeBadArgs, "cli: must specify a path or -id")
+	}
+
+	// The artifact metadata only holds strings
+	opts.Metadata = make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		opts.Metadata[k] = fmt.Sprint(v)
+	}
+
+	start := time.Now()
+
+	var r io.ReadSeeker
+	var size int64
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return c.fail(ExitCodeBadArgs, "error opening artifact: %s", err)
+		}
+		defer f.Close()
+
+		fi, err := f.Stat()
+		if err != nil {
+			return c.fail(ExitCodeBadArgs, "error opening artifact: %s", err)
+		}
+		if fi.IsDir() {
+			return c.fail(ExitCodeBadArgs,
+				"error opening artifact: %s is a directory, not a file", path)
+		}
+
+		r, size = f, fi.Size()
+	}
+
+	client, err := c.client()
+	if err != nil {
+		return c.fail(requestExitCode(err, ExitCodeUploadError),
+			"error starting upload: upload: %s", err)
+	}
+
+	// Cancel everything that is in flight when we are interrupted
+	ctx, cancel := c.interruptContext()
+	defer cancel()
+
+	uploader := &upload.Uploader{
+		Client:       client,
+		Retry:        retryOpts,
+		ProgressFunc: c.progressFunc(fmt.Sprintf("Uploading %s", opts.Slug)),
+	}
+
+	result, err := uploader.UploadArtifact(ctx, r, size, &opts)
+	if err != nil {
+		if ctx.Err() != nil {
+			return c.interrupted("uploading")
+		}
+
+		return c.fail(requestExitCode(err, ExitCodeUploadError),
+			"error uploading: %s", err)
+	}
+
+	if c.format == formatJSON {
+		c.printJSON(&artifactResult{
+			Slug:     opts.Slug,
+			Type:     opts.Type,
+			ID:       opts.ID,
+			Version:  result.Version,
+			Size:     result.Size,
+			SHA256:   result.Checksum,
+			Metadata: result.Metadata,
+			Elapsed:  time.Since(start).Seconds(),
+			Server:   client.URL.String(),
+		})
+		return ExitCodeOK
+	}
+
+	fmt.Fprintf(c.outStream, "Uploaded artifact %s (%s) v%d\n",
+		opts.Slug, opts.Type, result.Version)
+	if result.Checksum != "" {
+		fmt.Fprintf(c.outStream, "SHA-256: %s\n", result.Checksum)
+	}
+	return ExitCodeOK
+}
+
+func (c *ArtifactCommand) Synopsis() string {
+	return "Uploads a file as a version of an Atlas artifact"
+}
+
+func (c *ArtifactCommand) Help() string {
+	helpText := `
+Usage: %s artifact [options] slug type [path]
+
+  Upload a new version of an artifact to Atlas, such as an image or a box
+  that was built outside of Atlas. The artifact is created if it does not
+  exist yet.
+
+  "slug" is the <username>/<artifact_name> to upload to, and "type" is the
+  type of the artifact, for example "amazon.image" or "vagrant.box".
+
+  If path is given, the file is uploaded as-is; it is not archived. Artifacts
+  that are stored elsewhere, such as cloud images, may be uploaded without a
+  file by giving their -id instead.
+
+Options:
+
+` + clientHelp + verifyHelp + configHelp + retryHelp + formatHelp + `  -id=<id>            The ID of the artifact within its type, such as the
+                      AMI ID of an image
+  -build-id=<id>      The ID of the Atlas build that produced the artifact
+  -compile-id=<id>    The ID of the Atlas compile that produced the artifact
+
+  -metadata<k=v>      Arbitrary key-value (string) metadata to be sent with the
+                      artifact; may be specified multiple times
+  -digest-headers     Send the SHA-256 checksum of the file in the Digest
+                      header of the upload request; it is always sent as the
+                      "archive.sha256" metadata
+
+  -debug              Turn on debug output
+`
+	return strings.TrimSpace(fmt.Sprintf(helpText, Name))
+}
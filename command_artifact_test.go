@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+// testArtifactServer returns a fake Atlas server that knows the
+// "hashicorp/image" artifact and accepts versions of it. The metadata of the
+// last version and its file are stored in the given pointers.
+func testArtifactServer(t *testing.T, metadata *map[string]string, file *[]byte) *httptest.Server {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+
+	mux.HandleFunc("/api/v1/authenticate", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/api/v1/artifacts/hashicorp/image", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"artifact": {"username": "hashicorp", "name": "image"}}`)
+	})
+	mux.HandleFunc("/api/v1/artifacts/hashicorp/image/vagrant.box", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Version struct {
+				Metadata map[string]string `json:"metadata"`
+			} `json:"artifact_version"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		*metadata = body.Version.Metadata
+
+		fmt.Fprintf(w, `{"upload_path": "%s/upload", "version": 2}`, server.URL)
+	})
+	mux.HandleFunc("/upload", func(w http.ResponseWriter, r *http.Request) {
+		*file, _ = ioutil.ReadAll(r.Body)
+	})
+
+	return server
+}
+
+func TestArtifactCommand(t *testing.T) {
+	var metadata map[string]string
+	var file []byte
+	server := testArtifactServer(t, &metadata, &file)
+	defer server.Close()
+
+	outStream, errStream := new(bytes.Buffer), new(bytes.Buffer)
+	cli := &CLI{outStream: outStream, errStream: errStream}
+	path := filepath.Join(testFixture("archive-dir"), "foo.txt")
+	args := []string{
+		"atlas-upload", "artifact",
+		"-address=" + server.URL,
+		"-metadata=provider=virtualbox",
+		"hashicorp/image", "vagrant.box",
+		path,
+	}
+
+	if status := cli.Run(args); status != ExitCodeOK {
+		t.Fatalf("expected %d to eq %d: %s", status, ExitCodeOK, errStream.String())
+	}
+
+	expected := "Uploaded artifact hashicorp/image (vagrant.box) v2"
+	if !bytes.Contains(outStream.Bytes(), []byte(expected)) {
+		t.Fatalf("expected %q to contain %q", outStream.String(), expected)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !bytes.Equal(file, data) {
+		t.Fatalf("expected %q to be %q", file, data)
+	}
+	if metadata["provider"] != "virtualbox" || metadata["archive.sha256"] == "" {
+		t.Fatalf("bad metadata: %#v", metadata)
+	}
+}
+
+func TestArtifactCommand_noFileOrID(t *testing.T) {
+	outStream, errStream := new(bytes.Buffer), new(bytes.Buffer)
+	cli := &CLI{outStream: outStream, errStream: errStream}
+	args := []string{
+		"atlas-upload", "artifact", "-no-config",
+		"hashicorp/image", "amazon.image",
+	}
+
+	if status := cli.Run(args); status != ExitCodeBadArgs {
+		t.Fatalf("expected %d to eq %d", status, ExitCodeBadArgs)
+	}
+}
+
+func TestArtifactCommand_directory(t *testing.T) {
+	outStream, errStream := new(bytes.Buffer), new(bytes.Buffer)
+	cli := &CLI{outStream: outStream, errStream: errStream}
+	args := []string{
+		"atlas-upload", "artifact", "-no-config",
+		"hashicorp/image", "vagrant.box", testFixture("archive-dir"),
+	}
+
+	if status := cli.Run(args); status != ExitCodeBadArgs {
+		t.Fatalf("expected %d to eq %d", status, ExitCodeBadArgs)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/atlas-upload-cli/archive"
+	"github.com/hashicorp/atlas-upload-cli/upload"
+)
+
+// BuildConfigCommand is the command that archives a Packer template
+// directory and uploads it as a new build configuration version in Atlas,
+// like "packer push" does.
+type BuildConfigCommand struct {
+	Meta
+}
+
+func (c *BuildConfigCommand) Run(args []string) int {
+	var template string
+	var vcsMetadata bool
+	var vcsMetadataPrefix string
+	var archiveOpts archive.ArchiveOpts
+	var opts upload.BuildConfigOpts
+	var retryOpts upload.RetryOpts
+
+	flags := c.flagSet("build-config", c.Help())
+	c.clientFlags(flags)
+	c.verifyFlags(flags)
+	c.configFlags(flags)
+	c.retryFlags(flags, &retryOpts)
+	c.formatFlags(flags)
+	c.archiveFlags(flags, &archiveOpts)
+	flags.StringVar(&template, "template", "template.json",
+		"name of the Packer template in the directory")
+	flags.BoolVar(&vcsMetadata, "vcs-metadata", true,
+		"send the VCS metadata along with the request")
+	flags.StringVar(&vcsMetadataPrefix, "vcs-metadata-prefix", "",
+		"prefix to add to the VCS metadata keys")
+	flags.Var((*FlagMetadataVar)(&opts.Metadata), "metadata",
+		"arbitrary metadata to pass along with the request")
+	flags.BoolVar(&opts.DigestHeaders, "digest-headers", false,
+		"send the checksum in the headers of the upload request")
+
+	if err := c.parseFlags(flags, args); err != nil {
+		return ExitCodeParseFlagsError
+	}
+
+	if !validFormat(c.format) {
+		return c.fail(ExitCodeBadArgs, "cli: invalid format %q", c.format)
+	}
+
+	parsedArgs := flags.Args()
+	if len(parsedArgs) != 2 {
+		code := c.fail(ExitCodeBadArgs, "cli: must specify two arguments - slug, path")
+		flags.Usage()
+		return code
+	}
+	slug, path := parsedArgs[0], parsedArgs[1]
+
+	if _, err := c.loadConfig(flags, path, &archiveOpts); err != nil {
+		return c.fail(ExitCodeBadArgs, "%s", err)
+	}
+
+	// If the path is the template itself, its directory is uploaded
+	if fi, err := os.Stat(path); err == nil && !fi.IsDir() {
+		path, template = filepath.Dir(path), filepath.Base(path)
+	}
+
+	builds, err := packerBuilds(filepath.Join(path, template))
+	if err != nil {
+		return c.fail(ExitCodeBadArgs, "%s", err)
+	}
+	opts.Slug = slug
+	opts.Builds = builds
+	start := time.Now()
+
+	// Only list the files for a dry run, without contacting Atlas
+	if c.dryRun {
+		return c.listArchive(path, &archiveOpts)
+	}
+
+	client, err := c.client()
+	if err != nil {
+		return c.fail(requestExitCode(err, ExitCodeUploadError),
+			"error starting upload: upload: %s", err)
+	}
+
+	// Cancel everything that is in flight when we are interrupted
+	ctx, cancel := c.interruptContext()
+	defer cancel()
+
+	r, err := archive.CreateArchiveContext(ctx, path, &archiveOpts)
+	if err != nil {
+		if ctx.Err() != nil {
+			return c.interrupted("archiving")
+		}
+
+		return c.fail(ExitCodeArchiveError, "error archiving: %s", err)
+	}
+	defer r.Close()
+
+	// Send the VCS metadata along with the user metadata, unless disabled
+	if vcsMetadata {
+		opts.Metadata = mergeMetadata(opts.Metadata, r.Metadata, vcsMetadataPrefix)
+	}
+	opts.SHA256 = r.SHA256
+
+	uploader := &upload.Uploader{
+		Client:       client,
+		Retry:        retryOpts,
+		ProgressFunc: c.progressFunc(fmt.Sprintf("Uploading %s", slug)),
+	}
+
+	result, err := uploader.UploadBuildConfig(ctx, r, r.Size, &opts)
+	if err != nil {
+		if ctx.Err() != nil {
+			return c.interrupted("uploading")
+		}
+
+		return c.fail(requestExitCode(err, ExitCodeUploadError),
+			"error uploading: %s", err)
+	}
+
+	if c.format == formatJSON {
+		c.printJSON(&buildConfigResult{
+			Slug:     slug,
+			Builds:   builds,
+			Size:     result.Size,
+			SHA256:   result.Checksum,
+			Files:    r.Files,
+			Metadata: result.Metadata,
+			Elapsed:  time.Since(start).Seconds(),
+			Server:   client.URL.String(),
+		})
+		return ExitCodeOK
+	}
+
+	names := make([]string, len(builds))
+	for i, b := range builds {
+		names[i] = b.Name
+	}
+	fmt.Fprintf(c.outStream, "Uploaded build configuration %s (%s)\n",
+		slug, strings.Join(names, ", "))
+	fmt.Fprintf(c.outStream, "SHA-256: %s\n", result.Checksum)
+	return ExitCodeOK
+}
+
+func (c *BuildConfigCommand) Synopsis() string {
+	return "Uploads a Packer build configuration to Atlas"
+}
+
+func (c *BuildConfigCommand) Help() string {
+	helpText := `
+Usage: %s build-config [options] slug path
+
+  Upload a Packer template and the files next to it to Atlas as a new build
+  configuration version, like "packer push" does. The build configuration is
+  created if it does not exist yet.
+
+  "slug" is the name of the <username>/<build_configuration_name> to upload
+  to. path is either the directory with the template, which is archived with
+  the same rules as the upload command, or the template itself, in which
+  case its directory is archived.
+
+  The builds of the configuration are read from the builders of the
+  template; a build has an artifact if an "atlas" post-processor runs for it.
+
+Options:
+
+` + archiveHelp + clientHelp + verifyHelp + configHelp + retryHelp + formatHelp + `  -vcs                Get lists of files to exclude and include from a version
+                      control system (Git, Mercurial or Subversion)
+
+  -template=<name>    The name of the Packer template in the directory
+                      (defaults to "template.json")
+
+  -metadata<k=v>      Arbitrary key-value (string) metadata to be sent with the
+                      upload; may be specified multiple times
+  -digest-headers     Send the SHA-256 checksum of the archive in the Digest
+                      header of the upload request; it is always sent as the
+                      "archive.sha256" metadata
+
+  -vcs-metadata=false Do not send the metadata detected by -vcs (branch,
+                      commit and remotes) with the upload
+  -vcs-metadata-prefix=<prefix>
+                      Prefix to add to the VCS metadata keys, for example
+                      "vcs." to send "vcs.commit" instead of "commit"; keys
+                      given with -metadata always take precedence
+
+  -debug              Turn on debug output
+`
+	return strings.TrimSpace(fmt.Sprintf(helpText, Name))
+}
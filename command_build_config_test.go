@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestBuildConfigCommand(t *testing.T) {
+	var builds []map[string]interface{}
+	var archiveData []byte
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	mux.HandleFunc("/api/v1/authenticate", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/api/v1/packer/build-configurations/hashicorp/web", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"username": "hashicorp", "name": "web"}`)
+	})
+	mux.HandleFunc("/api/v1/packer/build-configurations/hashicorp/web/versions", func(w http.ResponseWriter, r *http.Request) {
+		var wrapper struct {
+			Version struct {
+				Builds []map[string]interface{} `json:"builds"`
+			} `json:"version"`
+		}
+		json.NewDecoder(r.Body).Decode(&wrapper)
+		builds = wrapper.Version.Builds
+
+		fmt.Fprintf(w, `{"upload_path": "%s/upload"}`, server.URL)
+	})
+	mux.HandleFunc("/upload", func(w http.ResponseWriter, r *http.Request) {
+		archiveData, _ = ioutil.ReadAll(r.Body)
+	})
+
+	outStream, errStream := new(bytes.Buffer), new(bytes.Buffer)
+	cli := &CLI{outStream: outStream, errStream: errStream}
+	args := []string{
+		"atlas-upload", "build-config",
+		"-address=" + server.URL,
+		"hashicorp/web",
+		filepath.Join(testFixture("packer-dir"), "template.json"),
+	}
+
+	if status := cli.Run(args); status != ExitCodeOK {
+		t.Fatalf("expected %d to eq %d: %s", status, ExitCodeOK, errStream.String())
+	}
+
+	expected := "Uploaded build configuration hashicorp/web (amazon-ebs, local, docker)"
+	if !bytes.Contains(outStream.Bytes(), []byte(expected)) {
+		t.Fatalf("expected %q to contain %q", outStream.String(), expected)
+	}
+	if len(builds) != 3 || builds[2]["name"] != "docker" || builds[2]["artifact"] != false {
+		t.Fatalf("bad builds: %#v", builds)
+	}
+
+	entries := tarEntries(t, bytes.NewReader(archiveData))
+	if !reflect.DeepEqual(entries, []string{"setup.sh", "template.json"}) {
+		t.Fatalf("bad entries: %#v", entries)
+	}
+}
+
+func TestBuildConfigCommand_noTemplate(t *testing.T) {
+	outStream, errStream := new(bytes.Buffer), new(bytes.Buffer)
+	cli := &CLI{outStream: outStream, errStream: errStream}
+	args := []string{
+		"atlas-upload", "build-config", "-no-config",
+		"hashicorp/web", testFixture("archive-dir"),
+	}
+
+	if status := cli.Run(args); status != ExitCodeBadArgs {
+		t.Fatalf("expected %d to eq %d", status, ExitCodeBadArgs)
+	}
+}
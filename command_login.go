@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// LoginCommand is the command that logs in to Atlas with a username and
+// password and saves the token, so that it doesn't have to be given with
+// -token or ATLAS_TOKEN.
+type LoginCommand struct {
+	Meta
+}
+
+func (c *LoginCommand) Run(args []string) int {
+	var username string
+
+	flags := c.flagSet("login", c.Help())
+	flags.StringVar(&c.address, "address", "",
+		"Atlas server address")
+	flags.StringVar(&username, "username", "",
+		"Atlas username or email")
+	c.configFlags(flags)
+	if err := c.parseFlags(flags, args); err != nil {
+		return ExitCodeParseFlagsError
+	}
+
+	if len(flags.Args()) != 0 {
+		fmt.Fprintf(c.errStream, "login: too many arguments\n")
+		flags.Usage()
+		return ExitCodeBadArgs
+	}
+
+	if _, err := c.loadConfig(flags, "", nil); err != nil {
+		fmt.Fprintf(c.errStream, "%s\n", err)
+		return ExitCodeBadArgs
+	}
+
+	client, err := c.client()
+	if err != nil {
+		fmt.Fprintf(c.errStream, "error creating client: %s\n", err)
+		return ExitCodeError
+	}
+
+	// The prompts go to stderr so that they are seen even if stdout is
+	// redirected
+	p := newPrompter(c.inStream, c.errStream)
+	fmt.Fprintf(c.errStream, "Logging in to %s\n", client.URL)
+	if username == "" {
+		username, err = p.ask("Username: ")
+		if err != nil {
+			fmt.Fprintf(c.errStream, "error reading username: %s\n", err)
+			return ExitCodeBadArgs
+		}
+	}
+
+	password, err := p.askSecret("Password: ")
+	if err != nil {
+		fmt.Fprintf(c.errStream, "error reading password: %s\n", err)
+		return ExitCodeBadArgs
+	}
+
+	token, err := client.Login(strings.TrimSpace(username), password)
+	if err != nil {
+		fmt.Fprintf(c.errStream, "error logging in: %s\n", err)
+		return requestExitCode(err, ExitCodeError)
+	}
+
+	logRedactor.addSecret(token)
+
+	path, err := saveToken(client.URL, token)
+	if err != nil {
+		fmt.Fprintf(c.errStream, "%s\n", err)
+		return ExitCodeError
+	}
+
+	fmt.Fprintf(c.outStream, "Logged in to %s, the token was saved to %s\n",
+		client.URL, path)
+	return ExitCodeOK
+}
+
+func (c *LoginCommand) Synopsis() string {
+	return "Logs in to Atlas and saves the API token"
+}
+
+func (c *LoginCommand) Help() string {
+	helpText := `
+Usage: %s login [options]
+
+  Log in to Atlas with a username and password, which are prompted for, and
+  save the API token that Atlas creates in the credentials file. The other
+  commands use the token for the server from that file if neither -token nor
+  ATLAS_TOKEN is given.
+
+  The credentials file is ~/.atlas-upload/credentials.json, or the file in
+  the ATLAS_CREDENTIALS_FILE environment variable. It is only readable by the
+  current user and holds one token per server address.
+
+Options:
+
+  -address=<url>      The address of the Atlas server
+  -username=<name>    The username or email to log in with, instead of
+                      prompting for it
+` + configHelp + `
+  -debug              Turn on debug output
+`
+	return strings.TrimSpace(fmt.Sprintf(helpText, Name))
+}
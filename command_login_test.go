@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoginCommand(t *testing.T) {
+	defer os.Setenv(CredentialsFileEnvVar, os.Getenv(CredentialsFileEnvVar))
+
+	dir, err := ioutil.TempDir("", "login")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv(CredentialsFileEnvVar, filepath.Join(dir, "credentials.json"))
+
+	var login, password string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/authenticate" {
+			http.NotFound(w, r)
+			return
+		}
+
+		r.ParseForm()
+		login, password = r.Form.Get("user[login]"), r.Form.Get("user[password]")
+		fmt.Fprint(w, `{"token": "new-token"}`)
+	}))
+	defer server.Close()
+
+	outStream, errStream := new(bytes.Buffer), new(bytes.Buffer)
+	cli := &CLI{
+		outStream: outStream,
+		errStream: errStream,
+		inStream:  strings.NewReader("hashicorp\nsecret\n"),
+	}
+	args := []string{"atlas-upload", "login", "-no-config", "-address=" + server.URL}
+
+	if status := cli.Run(args); status != ExitCodeOK {
+		t.Fatalf("expected %d to eq %d: %s", status, ExitCodeOK, errStream.String())
+	}
+
+	if login != "hashicorp" || password != "secret" {
+		t.Fatalf("bad credentials: %q, %q", login, password)
+	}
+	if !strings.Contains(errStream.String(), "Password: ") {
+		t.Fatalf("expected a password prompt, got %q", errStream.String())
+	}
+
+	u, _ := url.Parse(server.URL)
+	if token, err := credentialsToken(u); err != nil || token != "new-token" {
+		t.Fatalf("expected the token to be saved, got %q (%v)", token, err)
+	}
+}
+
+func TestLoginCommand_noPassword(t *testing.T) {
+	outStream, errStream := new(bytes.Buffer), new(bytes.Buffer)
+	cli := &CLI{
+		outStream: outStream,
+		errStream: errStream,
+		inStream:  strings.NewReader(""),
+	}
+	args := []string{"atlas-upload", "login", "-no-config", "-username=hashicorp"}
+
+	if status := cli.Run(args); status != ExitCodeBadArgs {
+		t.Fatalf("expected %d to eq %d", status, ExitCodeBadArgs)
+	}
+}
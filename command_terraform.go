@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/atlas-go/v1"
+	"github.com/hashicorp/atlas-upload-cli/archive"
+	"github.com/hashicorp/atlas-upload-cli/upload"
+)
+
+// TerraformCommand is the command that archives a Terraform configuration
+// and uploads it as a new configuration version in Atlas, like
+// "terraform push" does.
+type TerraformCommand struct {
+	Meta
+}
+
+func (c *TerraformCommand) Run(args []string) int {
+	var varFile string
+	var vars []atlas.TFVar
+	var replaceVars bool
+	var vcsMetadata bool
+	var vcsMetadataPrefix string
+	var metadata map[string]interface{}
+	var archiveOpts archive.ArchiveOpts
+	var opts upload.TerraformOpts
+	var retryOpts upload.RetryOpts
+
+	flags := c.flagSet("terraform", c.Help())
+	c.clientFlags(flags)
+	c.verifyFlags(flags)
+	c.configFlags(flags)
+	c.retryFlags(flags, &retryOpts)
+	c.formatFlags(flags)
+	c.archiveFlags(flags, &archiveOpts)
+	flags.Var(&FlagVariablesVar{Variables: &vars}, "var",
+		"Terraform string variable to set, as key=value")
+	flags.Var(&FlagVariablesVar{Variables: &vars, HCL: true}, "hcl-var",
+		"Terraform HCL variable to set, as key=value")
+	flags.StringVar(&varFile, "var-file", "",
+		"file with Terraform variables to set")
+	flags.BoolVar(&replaceVars, "replace-vars", false,
+		"do not keep the variables of the latest version")
+	flags.BoolVar(&vcsMetadata, "vcs-metadata", true,
+		"send the VCS metadata along with the request")
+	flags.StringVar(&vcsMetadataPrefix, "vcs-metadata-prefix", "",
+		"prefix to add to the VCS metadata keys")
+	flags.Var((*FlagMetadataVar)(&metadata), "metadata",
+		"arbitrary metadata to pass along with the request")
+	flags.BoolVar(&opts.DigestHeaders, "digest-headers", false,
+		"send the checksum in the headers of the upload request")
+
+	if err := c.parseFlags(flags, args); err != nil {
+		return ExitCodeParseFlagsError
+	}
+
+	if !validFormat(c.format) {
+		return c.fail(ExitCodeBadArgs, "cli: invalid format %q", c.format)
+	}
+
+	parsedArgs := flags.Args()
+	if len(parsedArgs) != 2 {
+		code := c.fail(ExitCodeBadArgs, "cli: must specify two arguments - slug, path")
+		flags.Usage()
+		return code
+	}
+	slug, path := parsedArgs[0], parsedArgs[1]
+
+	if _, err := c.loadConfig(flags, path, &archiveOpts); err != nil {
+		return c.fail(ExitCodeBadArgs, "%s", err)
+	}
+
+	// Variables given as flags override the ones in the file
+	if varFile != "" {
+		fileVars, err := loadVarFile(varFile)
+		if err != nil {
+			return c.fail(ExitCodeBadArgs, "%s", err)
+		}
+		vars = append(fileVars, vars...)
+	}
+	start := time.Now()
+
+	// Only list the files for a dry run, without contacting Atlas
+	if c.dryRun {
+		return c.listArchive(path, &archiveOpts)
+	}
+
+	client, err := c.client()
+	if err != nil {
+		return c.fail(requestExitCode(err, ExitCodeUploadError),
+			"error starting upload: upload: %s", err)
+	}
+
+	// Cancel everything that is in flight when we are interrupted
+	ctx, cancel := c.interruptContext()
+	defer cancel()
+
+	r, err := archive.CreateArchiveContext(ctx, path, &archiveOpts)
+	if err != nil {
+		if ctx.Err() != nil {
+			return c.interrupted("archiving")
+		}
+
+		return c.fail(ExitCodeArchiveError, "error archiving: %s", err)
+	}
+	defer r.Close()
+
+	// Send the VCS metadata along with the user metadata, unless disabled
+	if vcsMetadata {
+		metadata = mergeMetadata(metadata, r.Metadata, vcsMetadataPrefix)
+		opts.Remotes = vcsRemotes(r.Metadata)
+	}
+
+	// The configuration metadata only holds strings
+	opts.Slug = slug
+	opts.Variables = uniqueVariables(vars)
+	opts.ReplaceVariables = replaceVars
+	opts.SHA256 = r.SHA256
+	opts.Metadata = make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		opts.Metadata[k] = fmt.Sprint(v)
+	}
+
+	uploader := &upload.Uploader{
+		Client:       client,
+		Retry:        retryOpts,
+		ProgressFunc: c.progressFunc(fmt.Sprintf("Uploading %s", slug)),
+	}
+
+	result, err := uploader.UploadTerraform(ctx, r, r.Size, &opts)
+	if err != nil {
+		if ctx.Err() != nil {
+			return c.interrupted("uploading")
+		}
+
+		return c.fail(requestExitCode(err, ExitCodeUploadError),
+			"error uploading: %s", err)
+	}
+
+	if c.format == formatJSON {
+		variables := make([]string, len(result.Variables))
+		for i, v := range result.Variables {
+			variables[i] = v.Key
+		}
+
+		c.printJSON(&terraformResult{
+			Slug:      slug,
+			Version:   result.Version,
+			Size:      result.Size,
+			SHA256:    result.Checksum,
+			Files:     r.Files,
+			Metadata:  result.Metadata,
+			Remotes:   opts.Remotes,
+			Variables: variables,
+			Elapsed:   time.Since(start).Seconds(),
+			Server:    client.URL.String(),
+		})
+		return ExitCodeOK
+	}
+
+	fmt.Fprintf(c.outStream, "Uploaded Terraform configuration %s v%d\n",
+		slug, result.Version)
+	fmt.Fprintf(c.outStream, "SHA-256: %s\n", result.Checksum)
+	return ExitCodeOK
+}
+
+// vcsRemotes returns the URLs of the remotes in the VCS metadata of an
+// archive, sorted by the name of the remote.
+func vcsRemotes(metadata map[string]string) []string {
+	var names []string
+	for k := range metadata {
+		if strings.HasPrefix(k, "remote.") {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+
+	var remotes []string
+	for _, name := range names {
+		remotes = append(remotes, metadata[name])
+	}
+
+	return remotes
+}
+
+func (c *TerraformCommand) Synopsis() string {
+	return "Uploads a Terraform configuration to Atlas"
+}
+
+func (c *TerraformCommand) Help() string {
+	helpText := `
+Usage: %s terraform [options] slug path
+
+  Upload a Terraform configuration to Atlas as a new configuration version,
+  like "terraform push" does.
+
+  "slug" is the name of the <username>/<configuration_name> to upload to,
+  and path is the directory with the configuration. It is archived with the
+  same rules as the upload command.
+
+  Variables that are set in the latest version but not given here are kept,
+  so that variables which were set in Atlas are not lost; use -replace-vars
+  to only set the given variables.
+
+Options:
+
+` + archiveHelp + clientHelp + verifyHelp + configHelp + retryHelp + formatHelp + `  -vcs                Get lists of files to exclude and include from a version
+                      control system (Git, Mercurial or Subversion)
+
+  -var=<k=v>          Terraform variable with a string value; may be
+                      specified multiple times
+  -hcl-var=<k=v>      Terraform variable with an HCL value, such as a list or
+                      a map; may be specified multiple times
+  -var-file=<file>    Load the variables from a tfvars file; variables given
+                      with -var and -hcl-var take precedence
+  -replace-vars       Do not keep the variables of the latest version that
+                      are not given
+
+  -metadata<k=v>      Arbitrary key-value (string) metadata to be sent with the
+                      upload; may be specified multiple times
+  -digest-headers     Send the SHA-256 checksum of the archive in the Digest
+                      header of the upload request; it is always sent as the
+                      "archive.sha256" metadata
+
+  -vcs-metadata=false Do not send the metadata detected by -vcs (branch,
+                      commit and remotes) with the upload
+  -vcs-metadata-prefix=<prefix>
+                      Prefix to add to the VCS metadata keys, for example
+                      "vcs." to send "vcs.commit" instead of "commit"; keys
+                      given with -metadata always take precedence
+
+  -debug              Turn on debug output
+`
+	return strings.TrimSpace(fmt.Sprintf(helpText, Name))
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/atlas-go/v1"
+)
+
+func TestTerraformCommand(t *testing.T) {
+	var version *atlas.TerraformConfigVersion
+	var archiveData []byte
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	mux.HandleFunc("/api/v1/authenticate", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/api/v1/terraform/configurations/hashicorp/infra/versions/latest", func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
+	mux.HandleFunc("/api/v1/terraform/configurations/hashicorp/infra/versions", func(w http.ResponseWriter, r *http.Request) {
+		var wrapper struct {
+			Version *atlas.TerraformConfigVersion `json:"version"`
+		}
+		json.NewDecoder(r.Body).Decode(&wrapper)
+		version = wrapper.Version
+
+		fmt.Fprintf(w, `{"upload_path": "%s/upload", "version": 4}`, server.URL)
+	})
+	mux.HandleFunc("/upload", func(w http.ResponseWriter, r *http.Request) {
+		archiveData, _ = ioutil.ReadAll(r.Body)
+	})
+
+	outStream, errStream := new(bytes.Buffer), new(bytes.Buffer)
+	cli := &CLI{outStream: outStream, errStream: errStream}
+	dir := testFixture("terraform-dir")
+	args := []string{
+		"atlas-upload", "terraform",
+		"-address=" + server.URL,
+		"-var-file=" + filepath.Join(dir, "terraform.tfvars"),
+		"-var=region=eu-west-1",
+		`-hcl-var=zones=["eu-west-1a"]`,
+		"-metadata=team=ops",
+		"hashicorp/infra",
+		dir,
+	}
+
+	if status := cli.Run(args); status != ExitCodeOK {
+		t.Fatalf("expected %d to eq %d: %s", status, ExitCodeOK, errStream.String())
+	}
+
+	expected := "Uploaded Terraform configuration hashicorp/infra v4"
+	if !bytes.Contains(outStream.Bytes(), []byte(expected)) {
+		t.Fatalf("expected %q to contain %q", outStream.String(), expected)
+	}
+
+	vars := []atlas.TFVar{
+		{Key: "count", Value: "3"},
+		{Key: "region", Value: "eu-west-1"},
+		{Key: "tags", Value: `{ "env" = "prod", "team" = "ops" }`, IsHCL: true},
+		{Key: "zones", Value: `["eu-west-1a"]`, IsHCL: true},
+	}
+	if !reflect.DeepEqual(version.TFVars, vars) {
+		t.Fatalf("expected %#v to be %#v", version.TFVars, vars)
+	}
+	if version.Metadata["team"] != "ops" {
+		t.Fatalf("bad metadata: %#v", version.Metadata)
+	}
+
+	entries := tarEntries(t, bytes.NewReader(archiveData))
+	if !reflect.DeepEqual(entries, []string{"main.tf", "terraform.tfvars"}) {
+		t.Fatalf("bad entries: %#v", entries)
+	}
+}
+
+func TestTerraformCommand_badVarFile(t *testing.T) {
+	outStream, errStream := new(bytes.Buffer), new(bytes.Buffer)
+	cli := &CLI{outStream: outStream, errStream: errStream}
+	args := []string{
+		"atlas-upload", "terraform", "-no-config",
+		"-var-file=" + filepath.Join(testFixture("config-bad"), "missing.tfvars"),
+		"hashicorp/infra",
+		testFixture("terraform-dir"),
+	}
+
+	if status := cli.Run(args); status != ExitCodeBadArgs {
+		t.Fatalf("expected %d to eq %d", status, ExitCodeBadArgs)
+	}
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/atlas-go/v1"
+	"github.com/hashicorp/atlas-upload-cli/archive"
+	"github.com/hashicorp/atlas-upload-cli/upload"
+)
+
+// UploadCommand is the command that archives a path and uploads it to an
+// application in Atlas. It is the default command.
+type UploadCommand struct {
+	Meta
+}
+
+func (c *UploadCommand) Run(args []string) int {
+	var version bool
+	var stream bool
+	var sendMD5, digestHeaders bool
+	var create string
+	var vcsMetadata bool
+	var vcsMetadataPrefix string
+	var archiveOpts archive.ArchiveOpts
+	var uploadOpts UploadOpts
+	var retryOpts upload.RetryOpts
+
+	flags := c.flagSet("upload", c.Help())
+	c.clientFlags(flags)
+	c.verifyFlags(flags)
+	c.configFlags(flags)
+	c.retryFlags(flags, &retryOpts)
+	c.formatFlags(flags)
+	c.archiveFlags(flags, &archiveOpts)
+	c.archiveFormatFlags(flags, &archiveOpts)
+	flags.BoolVar(&vcsMetadata, "vcs-metadata", true,
+		"send the VCS metadata along with the request")
+	flags.StringVar(&vcsMetadataPrefix, "vcs-metadata-prefix", "",
+		"prefix to add to the VCS metadata keys")
+	flags.Var((*FlagMetadataVar)(&uploadOpts.Metadata), "metadata",
+		"arbitrary metadata to pass along with the request")
+	flags.BoolVar(&stream, "stream", false,
+		"send the archive while it is created, without a temporary file")
+	flags.BoolVar(&sendMD5, "md5", false,
+		"send the MD5 checksum of the archive too")
+	flags.BoolVar(&digestHeaders, "digest-headers", false,
+		"send the checksums in the headers of the upload request")
+	flags.StringVar(&create, "create", "",
+		"whether to create the application: never, always or prompt")
+	flags.BoolVar(&version, "version", false,
+		"display the version")
+
+	// Parse all the flags
+	if err := c.parseFlags(flags, args); err != nil {
+		return ExitCodeParseFlagsError
+	}
+
+	// Version
+	if version {
+		fmt.Fprintf(c.errStream, "%s v%s\n", Name, Version)
+		return ExitCodeOK
+	}
+
+	// Get the parsed arguments (the ones left over after all the flags have been
+	// parsed)
+	parsedArgs := flags.Args()
+
+	if !validFormat(c.format) {
+		return c.fail(ExitCodeBadArgs, "cli: invalid format %q", c.format)
+	}
+
+	if create != "" && !validCreate(create) {
+		return c.fail(ExitCodeBadArgs, "cli: invalid value %q for -create", create)
+	}
+
+	if len(parsedArgs) < 1 || len(parsedArgs) > 2 {
+		code := c.fail(ExitCodeBadArgs, "cli: must specify two arguments - slug, path")
+		flags.Usage()
+		return code
+	}
+
+	// Get the path to archive and load the defaults for everything that was
+	// not given on the command line
+	path := parsedArgs[len(parsedArgs)-1]
+	config, err := c.loadConfig(flags, path, &archiveOpts)
+	if err != nil {
+		return c.fail(ExitCodeBadArgs, "%s", err)
+	}
+
+	if !archive.ValidFormat(archiveOpts.Format) {
+		return c.fail(ExitCodeBadArgs, "cli: invalid archive format %q", archiveOpts.Format)
+	}
+
+	// The checksums of a streamed archive are only known once it was sent
+	if stream && sendMD5 {
+		return c.fail(ExitCodeBadArgs, "cli: -stream can't be used with -md5")
+	}
+
+	// Get the name of the app, which may come from the config
+	var slug string
+	if len(parsedArgs) == 2 {
+		slug = parsedArgs[0]
+	} else if config != nil && config.Slug != "" {
+		slug = config.Slug
+	} else {
+		code := c.fail(ExitCodeBadArgs, "cli: must specify two arguments - slug, path")
+		flags.Usage()
+		return code
+	}
+	start := time.Now()
+
+	// Only list the files for a dry run, without contacting Atlas
+	if c.dryRun {
+		return c.listArchive(path, &archiveOpts)
+	}
+
+	uploadOpts.Slug = slug
+	uploadOpts.URL = c.address
+	uploadOpts.Token = c.token
+	uploadOpts.Verify = c.verify
+
+	client, err := atlasClient(&uploadOpts)
+	if err != nil {
+		return c.fail(requestExitCode(err, ExitCodeUploadError),
+			"error starting upload: upload: %s", err)
+	}
+
+	// Cancel everything that is in flight when we are interrupted
+	ctx, cancel := c.interruptContext()
+	defer cancel()
+
+	uploader := &upload.Uploader{
+		Client:       client,
+		Retry:        retryOpts,
+		ProgressFunc: c.progressFunc(fmt.Sprintf("Uploading %s", slug)),
+	}
+
+	// Fail before archiving if the application doesn't exist and won't be
+	// created, so that a misspelled slug is noticed right away
+	if c.verify && c.createMode(create) == createNever {
+		if _, err := uploader.App(ctx, slug); err != nil {
+			return c.uploadFailed(ctx, err, slug)
+		}
+	}
+
+	var result *upload.Result
+	var info *archive.Archive
+	if stream {
+		result, info, err = c.uploadStream(ctx, uploader, path, slug, &archiveOpts,
+			&uploadOpts, vcsMetadata, vcsMetadataPrefix, digestHeaders, create)
+	} else {
+		// Get the archive reader
+		var r *archive.Archive
+		r, err = archive.CreateArchiveContext(ctx, path, &archiveOpts)
+		if err != nil {
+			if ctx.Err() != nil {
+				return c.interrupted("archiving")
+			}
+
+			return c.fail(ExitCodeArchiveError, "error archiving: %s", err)
+		}
+		defer r.Close()
+
+		// Send the VCS metadata along with the user metadata, unless disabled
+		if vcsMetadata {
+			uploadOpts.Metadata = mergeMetadata(
+				uploadOpts.Metadata, r.Metadata, vcsMetadataPrefix)
+		}
+
+		opts := &upload.Opts{
+			Slug:          uploadOpts.Slug,
+			Metadata:      uploadOpts.Metadata,
+			SHA256:        r.SHA256,
+			DigestHeaders: digestHeaders,
+		}
+		if sendMD5 {
+			opts.MD5 = r.MD5
+		}
+		opts.ConfirmCreate = c.confirmCreate(create)
+
+		info = r
+		result, err = uploader.Upload(ctx, r, r.Size, opts)
+	}
+	if err != nil {
+		return c.uploadFailed(ctx, err, slug)
+	}
+
+	if c.format == formatJSON {
+		c.printJSON(&uploadResult{
+			Slug:     slug,
+			Version:  result.Version,
+			Size:     result.Size,
+			SHA256:   result.Checksum,
+			MD5:      result.MD5,
+			Files:    info.Files,
+			Format:   info.Format,
+			Metadata: result.Metadata,
+			Elapsed:  time.Since(start).Seconds(),
+			Server:   client.URL.String(),
+			Created:  result.Created,
+
+			Entries:          info.Entries,
+			UncompressedSize: info.UncompressedSize,
+		})
+		return ExitCodeOK
+	}
+
+	fmt.Fprintf(c.outStream, "Uploaded %s v%d\n", slug, result.Version)
+	fmt.Fprintf(c.outStream, "SHA-256: %s\n", result.Checksum)
+	if result.MD5 != "" {
+		fmt.Fprintf(c.outStream, "MD5: %s\n", result.MD5)
+	}
+	if info.Entries > 0 {
+		fmt.Fprintf(c.outStream, "Entries: %d (%d bytes uncompressed)\n",
+			info.Entries, info.UncompressedSize)
+	}
+	return ExitCodeOK
+}
+
+// archiveError is an error that happened while a streamed archive was
+// written, as opposed to while it was sent.
+type archiveError struct {
+	Err error
+}
+
+func (e *archiveError) Error() string {
+	return e.Err.Error()
+}
+
+// uploadFailed reports the error of a failed upload to the application with
+// the given slug, and returns the exit code.
+func (c *UploadCommand) uploadFailed(ctx context.Context, err error, slug string) int {
+	if ctx.Err() != nil {
+		return c.interrupted("uploading")
+	}
+	if _, ok := err.(*archiveError); ok {
+		return c.fail(ExitCodeArchiveError, "error archiving: %s", err)
+	}
+
+	code := requestExitCode(err, ExitCodeUploadError)
+	if e, ok := err.(*upload.Error); ok && e.Err == atlas.ErrNotFound {
+		return c.fail(code, "error uploading: application %s doesn't exist "+
+			"and was not created, use -create=always to create it", slug)
+	}
+
+	return c.fail(code, "error uploading: %s", err)
+}
+
+// uploadStream uploads the archive of the path with -stream: the archive is
+// written while it is sent, instead of to a temporary file first. The result
+// and the description of the archive are returned.
+func (c *UploadCommand) uploadStream(ctx context.Context, uploader *upload.Uploader,
+	path, slug string, archiveOpts *archive.ArchiveOpts, uploadOpts *UploadOpts,
+	vcsMetadata bool, vcsMetadataPrefix string, digestHeaders bool,
+	create string) (*upload.Result, *archive.Archive, error) {
+	s, err := archive.NewStreamContext(ctx, path, archiveOpts)
+	if err != nil {
+		return nil, nil, &archiveError{Err: err}
+	}
+
+	if vcsMetadata {
+		uploadOpts.Metadata = mergeMetadata(
+			uploadOpts.Metadata, s.Metadata, vcsMetadataPrefix)
+	}
+
+	opts := &upload.Opts{
+		Slug:          slug,
+		Metadata:      uploadOpts.Metadata,
+		DigestHeaders: digestHeaders,
+		ConfirmCreate: c.confirmCreate(create),
+	}
+
+	var info *archive.Archive
+	result, err := uploader.UploadStream(ctx, func(w io.Writer) error {
+		a, err := s.WriteContext(ctx, w)
+		if err != nil {
+			return &archiveError{Err: err}
+		}
+
+		info = a
+		return nil
+	}, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return result, info, nil
+}
+
+// Values of -create.
+const (
+	createNever  = "never"
+	createAlways = "always"
+	createPrompt = "prompt"
+)
+
+// validCreate says whether the value given with -create is supported.
+func validCreate(create string) bool {
+	return create == createNever || create == createAlways || create == createPrompt
+}
+
+// createMode returns the value of -create that applies. Without a value,
+// the user is asked if the input is a terminal, and the application is never
+// created otherwise, so that a typo in the slug of an unattended upload fails
+// instead of creating a new application.
+func (c *UploadCommand) createMode(create string) string {
+	if create != "" {
+		return create
+	}
+	if newPrompter(c.inStream, c.errStream).terminal() {
+		return createPrompt
+	}
+
+	return createNever
+}
+
+// confirmCreate returns the upload.Opts ConfirmCreate function for the value
+// of -create, see createMode.
+func (c *UploadCommand) confirmCreate(create string) func(string) (bool, error) {
+	p := newPrompter(c.inStream, c.errStream)
+	switch c.createMode(create) {
+	case createAlways:
+		return nil
+	case createPrompt:
+		return func(slug string) (bool, error) {
+			answer, err := p.ask(fmt.Sprintf(
+				"Application %s doesn't exist. Create it? [y/N]: ", slug))
+			if err != nil {
+				return false, fmt.Errorf("error reading answer: %s", err)
+			}
+
+			answer = strings.ToLower(strings.TrimSpace(answer))
+			return answer == "y" || answer == "yes", nil
+		}
+	default:
+		return func(string) (bool, error) {
+			return false, nil
+		}
+	}
+}
+
+func (c *UploadCommand) Synopsis() string {
+	return "Uploads application code to Atlas (default)"
+}
+
+func (c *UploadCommand) Help() string {
+	helpText := `
+Usage: %s upload [options] [slug] path
+
+  Upload application code or artifacts to Atlas for initiating deployments.
+  This is the default command, so "upload" may be omitted.
+
+  "slug" is the name of the <username>/<application_name> to upload to within Atlas.
+
+  If path is a directory, it will be compressed (gzip tar, unless
+  -archive-format is given) and uploaded in its entirety. The root of the
+  archive will be the path. For clarity: if you upload the "foo/"
+  directory, then the file "foo/version" will be "version" in the archive
+  since "foo/" is the root.
+
+  If path is a file that is already a tar archive, compressed or not, it is
+  checked and uploaded as-is, and so is a zip with -archive-format=zip; any
+  other file is archived on its own.
+
+  A path must be specified. Due to the nature of this application, it does
+  not default to using the current working directory automatically. The
+  slug may be omitted if it is set in the config file.
+
+  The defaults for the options below may be set in a .atlas-upload.hcl or
+  .atlas-upload.json config file in the path or the current directory.
+  Options given on the command line override the config file.
+
+Options:
+
+` + archiveHelp + archiveFormatHelp + clientHelp + verifyHelp + configHelp + retryHelp + formatHelp + `  -vcs                Get lists of files to exclude and include from a version
+                      control system (Git, Mercurial or Subversion)
+
+  -metadata<k=v>      Arbitrary key-value (string) metadata to be sent with the
+                      upload; may be specified multiple times
+
+  -stream             Send the archive while it is created, instead of writing
+                      it to a temporary file first, for directories that are
+                      too large to buffer; can't be used with -md5, since the
+                      checksums are only known at the end
+
+  -create=<mode>      Whether to create the application if it doesn't exist:
+                      "never" fails the upload (before archiving, unless
+                      -verify=false), "always" creates it and "prompt" asks
+                      first; defaults to "prompt" if the input is a terminal
+                      and to "never" otherwise
+
+  -md5                Also send the MD5 checksum of the archive as the
+                      "archive.md5" metadata; the SHA-256 checksum is always
+                      sent as "archive.sha256"
+  -digest-headers     Send the checksums in the Digest and Content-MD5
+                      headers of the upload request, so that the server can
+                      verify what it received
+
+  -vcs-metadata=false Do not send the metadata detected by -vcs (branch,
+                      commit and remotes) with the upload
+  -vcs-metadata-prefix=<prefix>
+                      Prefix to add to the VCS metadata keys, for example
+                      "vcs." to send "vcs.commit" instead of "commit"; keys
+                      given with -metadata always take precedence
+
+  -debug              Turn on debug output
+  -version            Print the version of this application
+`
+	return strings.TrimSpace(fmt.Sprintf(helpText, Name))
+}
@@ -0,0 +1,503 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testAtlasServer is a fake Atlas server that knows the "hashicorp/project"
+// application and accepts uploads for it.
+type testAtlasServer struct {
+	*httptest.Server
+
+	// Metadata is the metadata that was sent when creating the version and
+	// Archive is the archive that was uploaded.
+	Metadata map[string]interface{}
+	Archive  []byte
+}
+
+func newTestAtlasServer(t *testing.T) *testAtlasServer {
+	s := &testAtlasServer{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authenticate", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/api/v1/vagrant/applications/hashicorp/project", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"username": "hashicorp", "name": "project"}`)
+	})
+	mux.HandleFunc("/api/v1/vagrant/applications/hashicorp/project/versions", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Application struct {
+				Metadata map[string]interface{} `json:"metadata"`
+			} `json:"application"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		s.Metadata = body.Application.Metadata
+
+		fmt.Fprintf(w, `{"upload_path": "%s/upload", "version": 7}`, s.URL)
+	})
+	mux.HandleFunc("/upload", func(w http.ResponseWriter, r *http.Request) {
+		s.Archive, _ = ioutil.ReadAll(r.Body)
+	})
+
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+func TestUploadCommand(t *testing.T) {
+	server := newTestAtlasServer(t)
+	defer server.Close()
+
+	outStream, errStream := new(bytes.Buffer), new(bytes.Buffer)
+	cli := &CLI{outStream: outStream, errStream: errStream}
+	args := []string{
+		"atlas-upload", "upload",
+		"-address=" + server.URL,
+		"-metadata=foo=bar",
+		"hashicorp/project",
+		testFixture("archive-dir"),
+	}
+
+	if status := cli.Run(args); status != ExitCodeOK {
+		t.Fatalf("expected %d to eq %d: %s", status, ExitCodeOK, errStream.String())
+	}
+
+	expected := "Uploaded hashicorp/project v7"
+	if !bytes.Contains(outStream.Bytes(), []byte(expected)) {
+		t.Fatalf("expected %q to contain %q", outStream.String(), expected)
+	}
+
+	if server.Metadata["foo"] != "bar" {
+		t.Fatalf("expected metadata to be sent, got %#v", server.Metadata)
+	}
+
+	sum := sha256.Sum256(server.Archive)
+	checksum := hex.EncodeToString(sum[:])
+	if server.Metadata["archive.sha256"] != checksum {
+		t.Fatalf("expected the checksum to be sent, got %#v", server.Metadata)
+	}
+	if !strings.Contains(outStream.String(), "SHA-256: "+checksum) {
+		t.Fatalf("expected the checksum to be printed, got %q", outStream.String())
+	}
+
+	entries := tarEntries(t, bytes.NewReader(server.Archive))
+	if len(entries) != 4 {
+		t.Fatalf("expected 4 entries, got %#v", entries)
+	}
+}
+
+func TestUploadCommand_verify(t *testing.T) {
+	server := newTestAtlasServer(t)
+	defer server.Close()
+
+	// Reject the token, but only in the preflight check
+	var verified bool
+	handler := server.Config.Handler
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/authenticate" {
+			verified = true
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+
+	outStream, errStream := new(bytes.Buffer), new(bytes.Buffer)
+	cli := &CLI{outStream: outStream, errStream: errStream}
+	args := []string{
+		"atlas-upload", "upload",
+		"-address=" + server.URL,
+		"-format=json",
+		"hashicorp/project",
+		testFixture("archive-dir"),
+	}
+
+	if status := cli.Run(args); status != ExitCodeAuthError {
+		t.Fatalf("expected %d to eq %d: %s", status, ExitCodeAuthError, outStream.String())
+	}
+	if !verified {
+		t.Fatal("expected the token to be verified")
+	}
+	if server.Archive != nil {
+		t.Fatal("expected nothing to be uploaded")
+	}
+
+	var result errorResult
+	if err := json.Unmarshal(outStream.Bytes(), &result); err != nil {
+		t.Fatalf("expected %q to be JSON: %s", outStream.String(), err)
+	}
+	if result.Category != "auth" {
+		t.Errorf("expected %q to eq %q", result.Category, "auth")
+	}
+
+	// The check is skipped with -verify=false
+	verified = false
+	outStream.Reset()
+	args = []string{
+		"atlas-upload", "upload",
+		"-address=" + server.URL,
+		"-verify=false",
+		"hashicorp/project",
+		testFixture("archive-dir"),
+	}
+
+	if status := cli.Run(args); status != ExitCodeOK {
+		t.Fatalf("expected %d to eq %d: %s", status, ExitCodeOK, errStream.String())
+	}
+	if verified {
+		t.Fatal("expected the token not to be verified")
+	}
+}
+
+func TestUploadCommand_create(t *testing.T) {
+	server := newTestAtlasServer(t)
+	defer server.Close()
+
+	var created bool
+	handler := server.Config.Handler
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/v1/vagrant/applications/hashicorp/project" && !created:
+			http.NotFound(w, r)
+		case r.URL.Path == "/api/v1/vagrant/applications":
+			created = true
+			fmt.Fprint(w, `{"username": "hashicorp", "name": "project"}`)
+		default:
+			handler.ServeHTTP(w, r)
+		}
+	})
+
+	// The input is not a terminal, so the application is not created
+	outStream, errStream := new(bytes.Buffer), new(bytes.Buffer)
+	cli := &CLI{outStream: outStream, errStream: errStream}
+	args := []string{
+		"atlas-upload", "upload",
+		"-address=" + server.URL,
+		"hashicorp/project",
+		testFixture("archive-dir"),
+	}
+
+	if status := cli.Run(args); status != ExitCodeNotFoundError {
+		t.Fatalf("expected %d to eq %d: %s", status, ExitCodeNotFoundError, errStream.String())
+	}
+	if created {
+		t.Fatal("expected the application not to be created")
+	}
+	if expected := "-create=always"; !strings.Contains(errStream.String(), expected) {
+		t.Fatalf("expected %q to contain %q", errStream.String(), expected)
+	}
+
+	// That is found out before archiving, so a path that can't be archived
+	// doesn't matter yet
+	outStream.Reset()
+	errStream.Reset()
+	args = []string{
+		"atlas-upload", "upload",
+		"-address=" + server.URL,
+		"-create=never",
+		"hashicorp/project",
+		testFixture("does-not-exist"),
+	}
+	if status := cli.Run(args); status != ExitCodeNotFoundError {
+		t.Fatalf("expected %d to eq %d: %s", status, ExitCodeNotFoundError, errStream.String())
+	}
+
+	// The answer to the prompt is read from the input
+	outStream.Reset()
+	errStream.Reset()
+	cli = &CLI{outStream: outStream, errStream: errStream, inStream: strings.NewReader("yes\n")}
+	args = []string{
+		"atlas-upload", "upload",
+		"-address=" + server.URL,
+		"-create=prompt",
+		"-format=json",
+		"hashicorp/project",
+		testFixture("archive-dir"),
+	}
+
+	if status := cli.Run(args); status != ExitCodeOK {
+		t.Fatalf("expected %d to eq %d: %s", status, ExitCodeOK, outStream.String())
+	}
+	if expected := "Create it? [y/N]"; !strings.Contains(errStream.String(), expected) {
+		t.Fatalf("expected %q to contain %q", errStream.String(), expected)
+	}
+
+	var result uploadResult
+	if err := json.Unmarshal(outStream.Bytes(), &result); err != nil {
+		t.Fatalf("expected %q to be JSON: %s", outStream.String(), err)
+	}
+	if !created || !result.Created {
+		t.Fatalf("expected the application to be created, got %#v", result)
+	}
+}
+
+func TestUploadCommand_debugRedacted(t *testing.T) {
+	server := newTestAtlasServer(t)
+	defer server.Close()
+
+	token := "debug-secret-token"
+	outStream, errStream := new(bytes.Buffer), new(bytes.Buffer)
+	cli := &CLI{outStream: outStream, errStream: errStream}
+	args := []string{
+		"atlas-upload", "upload",
+		"-address=" + server.URL,
+		"-token=" + token,
+		"-debug",
+		"hashicorp/project",
+		testFixture("archive-dir"),
+	}
+
+	if status := cli.Run(args); status != ExitCodeOK {
+		t.Fatalf("expected %d to eq %d: %s", status, ExitCodeOK, errStream.String())
+	}
+
+	for _, s := range []string{token, server.URL + "/upload"} {
+		if strings.Contains(errStream.String(), s) {
+			t.Errorf("expected the debug output not to contain %q", s)
+		}
+	}
+	if !strings.Contains(errStream.String(), "[DEBUG]") {
+		t.Fatalf("expected debug output, got %q", errStream.String())
+	}
+}
+
+func TestUploadCommand_json(t *testing.T) {
+	server := newTestAtlasServer(t)
+	defer server.Close()
+
+	outStream, errStream := new(bytes.Buffer), new(bytes.Buffer)
+	cli := &CLI{outStream: outStream, errStream: errStream}
+	args := []string{
+		"atlas-upload",
+		"-format=json",
+		"-address=" + server.URL,
+		"-metadata=foo=bar",
+		"hashicorp/project",
+		testFixture("archive-dir"),
+	}
+
+	if status := cli.Run(args); status != ExitCodeOK {
+		t.Fatalf("expected %d to eq %d: %s", status, ExitCodeOK, errStream.String())
+	}
+
+	var result uploadResult
+	if err := json.Unmarshal(outStream.Bytes(), &result); err != nil {
+		t.Fatalf("expected %q to be JSON: %s", outStream.String(), err)
+	}
+
+	if result.Slug != "hashicorp/project" {
+		t.Errorf("expected %q to eq %q", result.Slug, "hashicorp/project")
+	}
+	if result.Version != 7 {
+		t.Errorf("expected %d to eq %d", result.Version, 7)
+	}
+	if result.Size != int64(len(server.Archive)) {
+		t.Errorf("expected %d to eq %d", result.Size, len(server.Archive))
+	}
+	if len(result.SHA256) != 64 {
+		t.Errorf("expected a sha256, got %q", result.SHA256)
+	}
+	if result.Files != 3 {
+		t.Errorf("expected %d to eq %d", result.Files, 3)
+	}
+	if result.Metadata["foo"] != "bar" {
+		t.Errorf("expected metadata to be reported, got %#v", result.Metadata)
+	}
+	if result.Server != server.URL {
+		t.Errorf("expected %q to eq %q", result.Server, server.URL)
+	}
+}
+
+func TestUploadCommand_config(t *testing.T) {
+	server := newTestAtlasServer(t)
+	defer server.Close()
+
+	outStream, errStream := new(bytes.Buffer), new(bytes.Buffer)
+	cli := &CLI{outStream: outStream, errStream: errStream}
+	args := []string{
+		"atlas-upload", "upload",
+		"-address=" + server.URL,
+		"-metadata=foo=bar",
+		testFixture("config-dir"),
+	}
+
+	if status := cli.Run(args); status != ExitCodeOK {
+		t.Fatalf("expected %d to eq %d: %s", status, ExitCodeOK, errStream.String())
+	}
+
+	expected := "Uploaded hashicorp/project v7"
+	if !bytes.Contains(outStream.Bytes(), []byte(expected)) {
+		t.Fatalf("expected %q to contain %q", outStream.String(), expected)
+	}
+
+	// -metadata wins over the config, the other keys are merged
+	if server.Metadata["foo"] != "bar" || server.Metadata["team"] != "ops" {
+		t.Fatalf("expected metadata to be merged, got %#v", server.Metadata)
+	}
+
+	entries := strings.Join(tarEntries(t, bytes.NewReader(server.Archive)), ",")
+	expected = ".atlas-upload.hcl,VERSION,app.txt"
+	if entries != expected {
+		t.Fatalf("expected %q to be %q", entries, expected)
+	}
+}
+
+func TestUploadCommand_noConfig(t *testing.T) {
+	outStream, errStream := new(bytes.Buffer), new(bytes.Buffer)
+	cli := &CLI{outStream: outStream, errStream: errStream}
+	args := []string{
+		"atlas-upload", "upload",
+		"-no-config",
+		testFixture("config-dir"),
+	}
+
+	if status := cli.Run(args); status != ExitCodeBadArgs {
+		t.Fatalf("expected %d to eq %d: %s", status, ExitCodeBadArgs, errStream.String())
+	}
+
+	expected := "must specify two arguments"
+	if !bytes.Contains(errStream.Bytes(), []byte(expected)) {
+		t.Fatalf("expected %q to contain %q", errStream.String(), expected)
+	}
+}
+
+func TestUploadCommand_badConfig(t *testing.T) {
+	outStream, errStream := new(bytes.Buffer), new(bytes.Buffer)
+	cli := &CLI{outStream: outStream, errStream: errStream}
+	args := []string{
+		"atlas-upload", "upload",
+		"-config=" + filepath.Join(testFixture("config-bad"), ".atlas-upload.hcl"),
+		"hashicorp/project",
+		testFixture("archive-dir"),
+	}
+
+	if status := cli.Run(args); status != ExitCodeBadArgs {
+		t.Fatalf("expected %d to eq %d: %s", status, ExitCodeBadArgs, errStream.String())
+	}
+
+	expected := `unknown option "excludes"`
+	if !bytes.Contains(errStream.Bytes(), []byte(expected)) {
+		t.Fatalf("expected %q to contain %q", errStream.String(), expected)
+	}
+}
+
+func TestUploadCommand_stream(t *testing.T) {
+	server := newTestAtlasServer(t)
+	defer server.Close()
+
+	outStream, errStream := new(bytes.Buffer), new(bytes.Buffer)
+	cli := &CLI{outStream: outStream, errStream: errStream}
+	args := []string{
+		"atlas-upload", "upload",
+		"-address=" + server.URL,
+		"-stream",
+		"-format=json",
+		"hashicorp/project",
+		testFixture("archive-dir"),
+	}
+
+	if status := cli.Run(args); status != ExitCodeOK {
+		t.Fatalf("expected %d to eq %d: %s", status, ExitCodeOK, errStream.String())
+	}
+
+	var result uploadResult
+	if err := json.Unmarshal(outStream.Bytes(), &result); err != nil {
+		t.Fatalf("expected %q to be JSON: %s", outStream.String(), err)
+	}
+
+	sum := sha256.Sum256(server.Archive)
+	if checksum := hex.EncodeToString(sum[:]); result.SHA256 != checksum {
+		t.Fatalf("expected %q to eq %q", result.SHA256, checksum)
+	}
+	if result.Files != 3 {
+		t.Fatalf("expected %d files, got %d", 3, result.Files)
+	}
+	if _, ok := server.Metadata["archive.sha256"]; ok {
+		t.Fatalf("expected no checksum in the metadata: %#v", server.Metadata)
+	}
+
+	entries := tarEntries(t, bytes.NewReader(server.Archive))
+	if len(entries) != 4 {
+		t.Fatalf("expected 4 entries, got %#v", entries)
+	}
+
+	// The checksum that -md5 needs is not known before a streamed archive
+	// is sent
+	args = []string{
+		"atlas-upload", "upload",
+		"-address=" + server.URL,
+		"-stream",
+		"-md5",
+		"hashicorp/project",
+		testFixture("archive-dir"),
+	}
+
+	if status := cli.Run(args); status != ExitCodeBadArgs {
+		t.Fatalf("expected %d to eq %d", status, ExitCodeBadArgs)
+	}
+}
+
+func TestUploadCommand_dryRunJSON(t *testing.T) {
+	outStream, errStream := new(bytes.Buffer), new(bytes.Buffer)
+	cli := &CLI{outStream: outStream, errStream: errStream}
+	args := []string{
+		"atlas-upload", "upload",
+		"-dry-run", "-show-excluded", "-format=json",
+		"-exclude=*.log",
+		"hashicorp/project",
+		testFixture("archive-dir"),
+	}
+
+	if status := cli.Run(args); status != ExitCodeOK {
+		t.Fatalf("expected %d to eq %d: %s", status, ExitCodeOK, errStream.String())
+	}
+
+	var result dryRunResult
+	if err := json.Unmarshal(outStream.Bytes(), &result); err != nil {
+		t.Fatalf("expected %q to be JSON: %s", outStream.String(), err)
+	}
+
+	expected := dryRunResult{
+		Entries: []dryRunEntry{
+			{Path: "debug.log", Size: 4, Excluded: true, Reason: `matched exclude pattern "*.log"`},
+			{Path: "foo.txt", Size: 4},
+			{Path: "sub/"},
+			{Path: "sub/bar.txt", Size: 4},
+		},
+		Files: 2,
+		Size:  8,
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("expected %#v to be %#v", result, expected)
+	}
+
+	// Errors are JSON documents too
+	outStream.Reset()
+	errStream.Reset()
+	args = []string{
+		"atlas-upload", "upload",
+		"-dry-run", "-format=json",
+		"hashicorp/project",
+		testFixture("does-not-exist"),
+	}
+	if status := cli.Run(args); status != ExitCodeArchiveError {
+		t.Fatalf("expected %d to eq %d", status, ExitCodeArchiveError)
+	}
+
+	var errResult errorResult
+	if err := json.Unmarshal(outStream.Bytes(), &errResult); err != nil {
+		t.Fatalf("expected %q to be JSON: %s", outStream.String(), err)
+	}
+	if errResult.Category != "archive" {
+		t.Fatalf("expected %q to eq %q", errResult.Category, "archive")
+	}
+}
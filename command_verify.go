@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// VerifyCommand is the command that checks that the Atlas server can be
+// reached and that the API token is valid.
+type VerifyCommand struct {
+	Meta
+}
+
+func (c *VerifyCommand) Run(args []string) int {
+	flags := c.flagSet("verify", c.Help())
+	c.clientFlags(flags)
+	c.configFlags(flags)
+	if err := c.parseFlags(flags, args); err != nil {
+		return ExitCodeParseFlagsError
+	}
+
+	if len(flags.Args()) != 0 {
+		fmt.Fprintf(c.errStream, "verify: too many arguments\n")
+		flags.Usage()
+		return ExitCodeBadArgs
+	}
+
+	if _, err := c.loadConfig(flags, "", nil); err != nil {
+		fmt.Fprintf(c.errStream, "%s\n", err)
+		return ExitCodeBadArgs
+	}
+
+	client, err := c.client()
+	if err != nil {
+		fmt.Fprintf(c.errStream, "error creating client: %s\n", err)
+		return ExitCodeError
+	}
+
+	if err := client.Verify(); err != nil {
+		fmt.Fprintf(c.errStream, "error verifying: %s\n", err)
+		return requestExitCode(err, ExitCodeError)
+	}
+
+	fmt.Fprintf(c.outStream, "Authenticated with %s\n", client.URL)
+	return ExitCodeOK
+}
+
+func (c *VerifyCommand) Synopsis() string {
+	return "Verifies the Atlas server address and API token"
+}
+
+func (c *VerifyCommand) Help() string {
+	helpText := `
+Usage: %s verify [options]
+
+  Verifies that the Atlas server can be reached and that the API token is
+  valid, without uploading anything.
+
+  Exits with 17 if the token is invalid and with 20 if the server can't be
+  reached, like the commands that upload do when they check the token
+  before archiving.
+
+Options:
+
+` + clientHelp + configHelp + `
+  -debug              Turn on debug output
+`
+	return strings.TrimSpace(fmt.Sprintf(helpText, Name))
+}
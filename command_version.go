@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// VersionCommand is the command that prints the version of this application.
+type VersionCommand struct {
+	Meta
+}
+
+func (c *VersionCommand) Run(args []string) int {
+	flags := c.flagSet("version", c.Help())
+	if err := c.parseFlags(flags, args); err != nil {
+		return ExitCodeParseFlagsError
+	}
+
+	if len(flags.Args()) != 0 {
+		fmt.Fprintf(c.errStream, "version: too many arguments\n")
+		flags.Usage()
+		return ExitCodeBadArgs
+	}
+
+	fmt.Fprintf(c.errStream, "%s v%s\n", Name, Version)
+	return ExitCodeOK
+}
+
+func (c *VersionCommand) Synopsis() string {
+	return "Prints the version of this application"
+}
+
+func (c *VersionCommand) Help() string {
+	helpText := `
+Usage: %s version
+
+  Prints the version of this application.
+`
+	return strings.TrimSpace(fmt.Sprintf(helpText, Name))
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/hashicorp/atlas-upload-cli/archive"
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/hcl/hcl/ast"
+)
+
+// configFiles are the names of the project config files that are looked for,
+// in order, in the target path and in the current directory.
+var configFiles = []string{".atlas-upload.hcl", ".atlas-upload.json"}
+
+// Config is a project config file. It holds the defaults for the options
+// that are otherwise given on the command line, so that they can be committed
+// next to the code that is uploaded. Options that are given on the command
+// line override the options in the file.
+type Config struct {
+	// Address and Token are the defaults for -address and -token. They are
+	// only used if neither the flag nor the ATLAS_ADDRESS and ATLAS_TOKEN
+	// environment variables are set.
+	Address string `hcl:"address"`
+	Token   string `hcl:"token"`
+
+	// Verify is the default for -verify.
+	Verify *bool `hcl:"verify"`
+
+	// Slug is the application to upload to if only a path is given.
+	Slug string `hcl:"slug"`
+
+	// VCS, Exclude and Include are the defaults for -vcs, -exclude and
+	// -include. Extra are the extra files to add to the archive, see
+	// archive.ArchiveOpts; relative paths are relative to the config file.
+	VCS     *bool             `hcl:"vcs"`
+	Exclude []string          `hcl:"exclude"`
+	Include []string          `hcl:"include"`
+	Extra   map[string]string `hcl:"extra"`
+
+	// LegacyGlobs is the default for -legacy-globs.
+	LegacyGlobs *bool `hcl:"legacy_globs"`
+
+	// Reproducible is the default for -reproducible.
+	Reproducible *bool `hcl:"reproducible"`
+
+	// NoIgnoreFile and NestedIgnoreFiles are the defaults for
+	// -no-ignore-file and -nested-ignore-files.
+	NoIgnoreFile      *bool `hcl:"no_ignore_file"`
+	NestedIgnoreFiles *bool `hcl:"nested_ignore_files"`
+
+	// NoValidation is the default for -no-validation.
+	NoValidation *bool `hcl:"no_validation"`
+
+	// ArchiveFormat is the default for -archive-format.
+	ArchiveFormat string `hcl:"archive_format"`
+
+	// CompressionLevel is the default for -compression-level.
+	CompressionLevel *int `hcl:"compression_level"`
+
+	// TempDir is the default for -temp-dir.
+	TempDir string `hcl:"temp_dir"`
+
+	// Metadata is the metadata to send with the upload. Keys given with
+	// -metadata take precedence.
+	Metadata map[string]string `hcl:"metadata"`
+
+	// Stream is the default for -stream.
+	Stream *bool `hcl:"stream"`
+
+	// Create is the default for -create.
+	Create string `hcl:"create"`
+
+	// MD5 and DigestHeaders are the defaults for -md5 and -digest-headers.
+	MD5           *bool `hcl:"md5"`
+	DigestHeaders *bool `hcl:"digest_headers"`
+
+	// VCSMetadata and VCSMetadataPrefix are the defaults for -vcs-metadata
+	// and -vcs-metadata-prefix.
+	VCSMetadata       *bool  `hcl:"vcs_metadata"`
+	VCSMetadataPrefix string `hcl:"vcs_metadata_prefix"`
+
+	// Path is the path of the file this config was loaded from.
+	Path string `hcl:"-"`
+}
+
+// configKeys are the keys that are allowed in a config file.
+var configKeys = map[string]struct{}{
+	"address":             {},
+	"token":               {},
+	"verify":              {},
+	"slug":                {},
+	"vcs":                 {},
+	"exclude":             {},
+	"include":             {},
+	"extra":               {},
+	"legacy_globs":        {},
+	"reproducible":        {},
+	"no_ignore_file":      {},
+	"nested_ignore_files": {},
+	"no_validation":       {},
+	"archive_format":      {},
+	"compression_level":   {},
+	"temp_dir":            {},
+	"metadata":            {},
+	"stream":              {},
+	"create":              {},
+	"md5":                 {},
+	"digest_headers":      {},
+	"vcs_metadata":        {},
+	"vcs_metadata_prefix": {},
+}
+
+// LoadConfig loads the config file at the given path. Both HCL and JSON are
+// accepted.
+func LoadConfig(path string) (*Config, error) {
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config: %s", err)
+	}
+
+	file, err := hcl.ParseBytes(d)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing config %s: %s", path, err)
+	}
+
+	// Catch typos, which the decoder would silently ignore
+	if list, ok := file.Node.(*ast.ObjectList); ok {
+		for _, item := range list.Items {
+			key := item.Keys[0].Token.Value().(string)
+			if _, ok := configKeys[key]; !ok {
+				return nil, fmt.Errorf("error parsing config %s: %s: unknown option %q",
+					path, item.Pos(), key)
+			}
+		}
+	}
+
+	var config Config
+	if err := hcl.DecodeObject(&config, file); err != nil {
+		return nil, fmt.Errorf("error parsing config %s: %s", path, err)
+	}
+	config.Path = path
+
+	// Make the extra files relative to the config file rather than to the
+	// current directory
+	for k, v := range config.Extra {
+		if v != archive.ExtraEntryDir && !filepath.IsAbs(v) {
+			config.Extra[k] = filepath.Join(filepath.Dir(path), v)
+		}
+	}
+
+	return &config, nil
+}
+
+// FindConfig looks for a config file in the given path, which is the path to
+// archive, and then in the current directory. If path is a file, its
+// directory is searched instead. It returns nil if there is no config file.
+func FindConfig(path string) (*Config, error) {
+	var dirs []string
+	if path != "" {
+		if fi, err := os.Stat(path); err == nil && !fi.IsDir() {
+			path = filepath.Dir(path)
+		}
+		dirs = append(dirs, path)
+	}
+	dirs = append(dirs, ".")
+
+	for _, dir := range dirs {
+		for _, name := range configFiles {
+			p := filepath.Join(dir, name)
+			if _, err := os.Stat(p); err != nil {
+				continue
+			}
+
+			log.Printf("[DEBUG] loading config from %s", p)
+			return LoadConfig(p)
+		}
+	}
+
+	return nil, nil
+}
+
+// apply sets the options from the config on the given FlagSet for every flag
+// that was not given on the command line, and sets the extra files on the
+// archive options. Flags that the FlagSet does not have are skipped, so the
+// same config can be applied to every command.
+func (c *Config) apply(flags *flag.FlagSet, opts *archive.ArchiveOpts) error {
+	set := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	values := make(map[string][]string)
+	if c.Address != "" && os.Getenv("ATLAS_ADDRESS") == "" {
+		values["address"] = []string{c.Address}
+	}
+	if c.Token != "" && os.Getenv("ATLAS_TOKEN") == "" {
+		values["token"] = []string{c.Token}
+	}
+	if c.Verify != nil {
+		values["verify"] = []string{strconv.FormatBool(*c.Verify)}
+	}
+	if c.VCS != nil {
+		values["vcs"] = []string{strconv.FormatBool(*c.VCS)}
+	}
+	if len(c.Exclude) > 0 {
+		values["exclude"] = c.Exclude
+	}
+	if len(c.Include) > 0 {
+		values["include"] = c.Include
+	}
+	if c.LegacyGlobs != nil {
+		values["legacy-globs"] = []string{strconv.FormatBool(*c.LegacyGlobs)}
+	}
+	if c.Reproducible != nil {
+		values["reproducible"] = []string{strconv.FormatBool(*c.Reproducible)}
+	}
+	if c.NoIgnoreFile != nil {
+		values["no-ignore-file"] = []string{strconv.FormatBool(*c.NoIgnoreFile)}
+	}
+	if c.NestedIgnoreFiles != nil {
+		values["nested-ignore-files"] = []string{strconv.FormatBool(*c.NestedIgnoreFiles)}
+	}
+	if c.NoValidation != nil {
+		values["no-validation"] = []string{strconv.FormatBool(*c.NoValidation)}
+	}
+	if c.ArchiveFormat != "" {
+		values["archive-format"] = []string{c.ArchiveFormat}
+	}
+	if c.CompressionLevel != nil {
+		values["compression-level"] = []string{strconv.Itoa(*c.CompressionLevel)}
+	}
+	if c.TempDir != "" {
+		values["temp-dir"] = []string{c.TempDir}
+	}
+	if c.Stream != nil {
+		values["stream"] = []string{strconv.FormatBool(*c.Stream)}
+	}
+	if c.Create != "" {
+		values["create"] = []string{c.Create}
+	}
+	if c.MD5 != nil {
+		values["md5"] = []string{strconv.FormatBool(*c.MD5)}
+	}
+	if c.DigestHeaders != nil {
+		values["digest-headers"] = []string{strconv.FormatBool(*c.DigestHeaders)}
+	}
+	if c.VCSMetadata != nil {
+		values["vcs-metadata"] = []string{strconv.FormatBool(*c.VCSMetadata)}
+	}
+	if c.VCSMetadataPrefix != "" {
+		values["vcs-metadata-prefix"] = []string{c.VCSMetadataPrefix}
+	}
+
+	for name, vs := range values {
+		if set[name] || flags.Lookup(name) == nil {
+			continue
+		}
+
+		for _, v := range vs {
+			if err := flags.Set(name, v); err != nil {
+				return fmt.Errorf("error parsing config %s: invalid value %q for %s: %s",
+					c.Path, v, name, err)
+			}
+		}
+	}
+
+	// Metadata is merged per key, with the keys from -metadata winning
+	if f := flags.Lookup("metadata"); f != nil && len(c.Metadata) > 0 {
+		metadata := f.Value.(*FlagMetadataVar)
+		if *metadata == nil {
+			*metadata = make(map[string]interface{})
+		}
+		for k, v := range c.Metadata {
+			if _, ok := (*metadata)[k]; !ok {
+				(*metadata)[k] = v
+			}
+		}
+	}
+
+	if opts != nil && len(c.Extra) > 0 {
+		if opts.Extra == nil {
+			opts.Extra = make(map[string]string)
+		}
+		for k, v := range c.Extra {
+			if _, ok := opts.Extra[k]; !ok {
+				opts.Extra[k] = v
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/atlas-upload-cli/archive"
+)
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(testFixture("config-dir"), ".atlas-upload.hcl")
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if config.Slug != "hashicorp/project" {
+		t.Errorf("expected %q to be %q", config.Slug, "hashicorp/project")
+	}
+	if !reflect.DeepEqual(config.Exclude, []string{"*.log"}) {
+		t.Errorf("bad exclude: %#v", config.Exclude)
+	}
+
+	expected := map[string]string{"team": "ops", "foo": "config"}
+	if !reflect.DeepEqual(config.Metadata, expected) {
+		t.Errorf("expected %#v to be %#v", config.Metadata, expected)
+	}
+
+	extra := filepath.Join(testFixture("archive-dir"), "foo.txt")
+	if config.Extra["VERSION"] != extra {
+		t.Errorf("expected %q to be %q", config.Extra["VERSION"], extra)
+	}
+
+	if config.VCS != nil || config.VCSMetadata != nil {
+		t.Errorf("expected unset options to be nil: %#v", config)
+	}
+}
+
+func TestLoadConfig_json(t *testing.T) {
+	path := filepath.Join(testFixture("config-json"), ".atlas-upload.json")
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if config.Address != "https://atlas.example.com" {
+		t.Errorf("bad address: %q", config.Address)
+	}
+	if config.VCS == nil || !*config.VCS {
+		t.Errorf("expected vcs to be true")
+	}
+	if config.VCSMetadata == nil || *config.VCSMetadata {
+		t.Errorf("expected vcs_metadata to be false")
+	}
+	if !reflect.DeepEqual(config.Include, []string{"app/**", "config/*"}) {
+		t.Errorf("bad include: %#v", config.Include)
+	}
+}
+
+func TestLoadConfig_unknownOption(t *testing.T) {
+	path := filepath.Join(testFixture("config-bad"), ".atlas-upload.hcl")
+	_, err := LoadConfig(path)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	if !strings.Contains(err.Error(), `unknown option "excludes"`) {
+		t.Fatalf("bad error: %s", err)
+	}
+}
+
+func TestFindConfig(t *testing.T) {
+	dir := testFixture("config-dir")
+	expected := filepath.Join(dir, ".atlas-upload.hcl")
+
+	cases := []string{
+		dir,
+		filepath.Join(dir, "app.txt"),
+	}
+	for _, path := range cases {
+		config, err := FindConfig(path)
+		if err != nil {
+			t.Fatalf("%s: err: %s", path, err)
+		}
+		if config == nil || config.Path != expected {
+			t.Fatalf("%s: expected config from %s, got %#v", path, expected, config)
+		}
+	}
+}
+
+func TestFindConfig_currentDir(t *testing.T) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.Chdir(cwd)
+
+	if err := os.Chdir(testFixture("config-json")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// The archive-dir fixture has no config, so the one in the current
+	// directory is used
+	config, err := FindConfig(filepath.Join(cwd, testFixture("archive-dir")))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if config == nil || config.Address != "https://atlas.example.com" {
+		t.Fatalf("expected config from the current directory, got %#v", config)
+	}
+}
+
+func TestFindConfig_none(t *testing.T) {
+	config, err := FindConfig(testFixture("archive-dir"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if config != nil {
+		t.Fatalf("expected no config, got %#v", config)
+	}
+}
+
+func TestConfigApply(t *testing.T) {
+	vcs := true
+	config := &Config{
+		Address:  "https://atlas.example.com",
+		VCS:      &vcs,
+		Exclude:  []string{"*.log"},
+		Include:  []string{"app/**"},
+		Metadata: map[string]string{"team": "ops", "foo": "config"},
+		Extra:    map[string]string{"VERSION": "/tmp/VERSION"},
+	}
+
+	var m Meta
+	var opts archive.ArchiveOpts
+	var metadata map[string]interface{}
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	m.clientFlags(flags)
+	m.archiveFlags(flags, &opts)
+	flags.Var((*FlagMetadataVar)(&metadata), "metadata", "")
+
+	args := []string{"-exclude=*.tmp", "-metadata=foo=flag"}
+	if err := flags.Parse(args); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := config.apply(flags, &opts); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if m.address != "https://atlas.example.com" {
+		t.Errorf("bad address: %q", m.address)
+	}
+	if !opts.VCS {
+		t.Errorf("expected vcs to be set")
+	}
+	if !reflect.DeepEqual(opts.Exclude, []string{"*.tmp"}) {
+		t.Errorf("expected -exclude to override the config: %#v", opts.Exclude)
+	}
+	if !reflect.DeepEqual(opts.Include, []string{"app/**"}) {
+		t.Errorf("bad include: %#v", opts.Include)
+	}
+	if opts.Extra["VERSION"] != "/tmp/VERSION" {
+		t.Errorf("bad extra: %#v", opts.Extra)
+	}
+
+	expected := map[string]interface{}{"team": "ops", "foo": "flag"}
+	if !reflect.DeepEqual(metadata, expected) {
+		t.Errorf("expected %#v to be %#v", metadata, expected)
+	}
+}
+
+func TestConfigApply_env(t *testing.T) {
+	defer os.Setenv("ATLAS_TOKEN", os.Getenv("ATLAS_TOKEN"))
+	os.Setenv("ATLAS_TOKEN", "env-token")
+
+	config := &Config{Token: "config-token"}
+
+	var m Meta
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	m.clientFlags(flags)
+	if err := config.apply(flags, nil); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if m.token != "" {
+		t.Fatalf("expected ATLAS_TOKEN to take precedence, got %q", m.token)
+	}
+}
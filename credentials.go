@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mitchellh/go-homedir"
+)
+
+// CredentialsFileEnvVar is the environment variable with the path of the
+// credentials file, which overrides the default path.
+const CredentialsFileEnvVar = "ATLAS_CREDENTIALS_FILE"
+
+// defaultCredentialsFile is where the credentials file is kept, relative to
+// the home directory of the user.
+const defaultCredentialsFile = "~/.atlas-upload/credentials.json"
+
+// credential is what the credentials file holds for a single server.
+type credential struct {
+	Token string `json:"token"`
+}
+
+// credentialsPath returns the path of the credentials file.
+func credentialsPath() (string, error) {
+	if path := os.Getenv(CredentialsFileEnvVar); path != "" {
+		return path, nil
+	}
+
+	return homedir.Expand(defaultCredentialsFile)
+}
+
+// credentialsKey returns the key of the server with the given address in the
+// credentials file, which ignores a trailing slash.
+func credentialsKey(u *url.URL) string {
+	return strings.TrimRight(u.String(), "/")
+}
+
+// loadCredentials loads the credentials file at the given path, keyed by
+// server address. A missing file has no credentials.
+func loadCredentials(path string) (map[string]*credential, error) {
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return make(map[string]*credential), nil
+		}
+		return nil, fmt.Errorf("error reading credentials: %s", err)
+	}
+
+	if fi, err := os.Stat(path); err == nil && fi.Mode().Perm()&0077 != 0 {
+		log.Printf("[WARN] credentials file %s can be read by other users (mode %s)",
+			path, fi.Mode().Perm())
+	}
+
+	var creds map[string]*credential
+	if err := json.Unmarshal(d, &creds); err != nil {
+		return nil, fmt.Errorf("error parsing credentials %s: %s", path, err)
+	}
+	if creds == nil {
+		creds = make(map[string]*credential)
+	}
+
+	return creds, nil
+}
+
+// credentialsToken returns the token for the server with the given address
+// from the credentials file, or "" if there is none.
+func credentialsToken(u *url.URL) (string, error) {
+	path, err := credentialsPath()
+	if err != nil {
+		return "", err
+	}
+
+	creds, err := loadCredentials(path)
+	if err != nil {
+		return "", err
+	}
+
+	c, ok := creds[credentialsKey(u)]
+	if !ok || c == nil {
+		return "", nil
+	}
+
+	log.Printf("[DEBUG] using the token for %s from %s", credentialsKey(u), path)
+	return c.Token, nil
+}
+
+// saveToken saves the token for the server with the given address in the
+// credentials file, keeping the tokens of other servers, and returns the
+// path of the file. The file is only readable by the user.
+func saveToken(u *url.URL, token string) (string, error) {
+	path, err := credentialsPath()
+	if err != nil {
+		return "", err
+	}
+
+	creds, err := loadCredentials(path)
+	if err != nil {
+		return "", err
+	}
+	creds[credentialsKey(u)] = &credential{Token: token}
+
+	d, err := json.MarshalIndent(creds, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("error saving credentials: %s", err)
+	}
+
+	// Write a temporary file, which is created with 0600, and move it into
+	// place so that the file is never partially written
+	f, err := ioutil.TempFile(dir, ".credentials")
+	if err != nil {
+		return "", fmt.Errorf("error saving credentials: %s", err)
+	}
+	_, err = f.Write(append(d, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("error saving credentials: %s", err)
+	}
+
+	return path, nil
+}
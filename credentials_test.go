@@ -0,0 +1,72 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestSaveToken(t *testing.T) {
+	defer os.Setenv(CredentialsFileEnvVar, os.Getenv(CredentialsFileEnvVar))
+
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	expected := filepath.Join(dir, "sub", "credentials.json")
+	os.Setenv(CredentialsFileEnvVar, expected)
+
+	first, _ := url.Parse("https://atlas.hashicorp.com")
+	second, _ := url.Parse("https://atlas.company.com/")
+	for _, u := range []*url.URL{first, second} {
+		path, err := saveToken(u, "token for "+u.Host)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if path != expected {
+			t.Fatalf("expected %q to be %q", path, expected)
+		}
+	}
+
+	for _, u := range []*url.URL{first, second} {
+		token, err := credentialsToken(u)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if token != "token for "+u.Host {
+			t.Fatalf("%s: bad token %q", u, token)
+		}
+	}
+
+	other, _ := url.Parse("https://other.example.com")
+	if token, err := credentialsToken(other); err != nil || token != "" {
+		t.Fatalf("expected no token, got %q (%v)", token, err)
+	}
+
+	if runtime.GOOS != "windows" {
+		fi, err := os.Stat(expected)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if fi.Mode().Perm() != 0600 {
+			t.Fatalf("expected mode 0600, got %s", fi.Mode().Perm())
+		}
+	}
+}
+
+func TestLoadCredentials_invalid(t *testing.T) {
+	path := tempFile(t)
+	defer os.Remove(path)
+
+	if err := ioutil.WriteFile(path, []byte("{"), 0600); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := loadCredentials(path); err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/atlas-upload-cli/archive"
+)
+
+// listArchive prints the entries that would be archived for the given path,
+// followed by the file count and total size, and returns the exit code. It is
+// used for -dry-run, so nothing is archived and Atlas is never contacted.
+// With -format=json, they are printed as a dryRunResult instead.
+//
+// Excluded entries are only printed if -show-excluded was given.
+func (m *Meta) listArchive(path string, opts *archive.ArchiveOpts) int {
+	entries, err := archive.List(path, opts)
+	if err != nil {
+		return m.fail(ExitCodeArchiveError, "error archiving: %s", err)
+	}
+
+	result := &dryRunResult{Entries: []dryRunEntry{}}
+	for _, e := range entries {
+		if e.Excluded() && !m.showExcluded {
+			continue
+		}
+
+		result.Entries = append(result.Entries, dryRunEntry{
+			Path:     entryName(e),
+			Size:     e.Size,
+			Excluded: e.Excluded(),
+			Reason:   e.Reason,
+		})
+		if !e.Excluded() && !e.Dir {
+			result.Files++
+			result.Size += e.Size
+		}
+	}
+
+	if m.format == formatJSON {
+		m.printJSON(result)
+		return ExitCodeOK
+	}
+
+	for _, e := range result.Entries {
+		if e.Excluded {
+			fmt.Fprintf(m.outStream, "- %s (%s)\n", e.Path, e.Reason)
+		} else {
+			fmt.Fprintf(m.outStream, "+ %s\n", e.Path)
+		}
+	}
+
+	fmt.Fprintf(m.outStream, "%d files, %d bytes\n", result.Files, result.Size)
+	return ExitCodeOK
+}
+
+// entryName returns the name to print for the entry, which has a trailing
+// slash for directories.
+func entryName(e *archive.Entry) string {
+	if e.Dir {
+		return e.Path + "/"
+	}
+
+	return e.Path
+}
@@ -2,7 +2,12 @@ package main
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
+
+	"github.com/hashicorp/atlas-go/v1"
+	"github.com/hashicorp/atlas-upload-cli/archive"
+	"github.com/hashicorp/hcl"
 )
 
 // FlagMetadataVar is a flag.Value implementation for parsing user variables
@@ -44,3 +49,66 @@ func (fsv *FlagSliceVar) Set(value string) error {
 	*fsv = append(*fsv, value)
 	return nil
 }
+
+// FlagVariablesVar is a flag.Value implementation for parsing Terraform
+// variables from the command-line in the format of 'key=value'. If HCL is
+// true, the value is parsed as HCL, such as a list or a map; otherwise it
+// is a string.
+type FlagVariablesVar struct {
+	Variables *[]atlas.TFVar
+	HCL       bool
+}
+
+func (v *FlagVariablesVar) String() string {
+	return ""
+}
+
+func (v *FlagVariablesVar) Set(raw string) error {
+	idx := strings.Index(raw, "=")
+	if idx == -1 {
+		return fmt.Errorf("Missing '=' in argument: %s", raw)
+	}
+
+	key, value := raw[0:idx], raw[idx+1:]
+	if v.HCL {
+		var parsed map[string]interface{}
+		if err := hcl.Decode(&parsed, fmt.Sprintf("%s = %s", key, value)); err != nil {
+			return fmt.Errorf("Invalid HCL in argument: %s: %s", raw, err)
+		}
+	}
+
+	*v.Variables = append(*v.Variables, atlas.TFVar{
+		Key:   key,
+		Value: value,
+		IsHCL: v.HCL,
+	})
+
+	return nil
+}
+
+// FlagCompressionLevelVar is a flag.Value implementation for the
+// compression level of an archive, from 1 (fastest) to 9 (best), where 0
+// stores the files without compressing them like it does for gzip. It sets
+// the archive.ArchiveOpts CompressionLevel, which is left nil for the
+// default level unless the flag is given.
+type FlagCompressionLevelVar struct {
+	Level **int
+}
+
+func (v *FlagCompressionLevelVar) String() string {
+	if v.Level == nil || *v.Level == nil {
+		return ""
+	}
+
+	return strconv.Itoa(**v.Level)
+}
+
+func (v *FlagCompressionLevelVar) Set(raw string) error {
+	level, err := strconv.Atoi(raw)
+	if err != nil || level < archive.NoCompression || level > archive.BestCompression {
+		return fmt.Errorf("Invalid compression level, must be 0 to 9: %s", raw)
+	}
+
+	*v.Level = &level
+	return nil
+}
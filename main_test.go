@@ -2,12 +2,19 @@ package main
 
 import (
 	"io/ioutil"
+	"os"
 	"path/filepath"
 	"testing"
 )
 
 const fixturesDir = "./test-fixtures"
 
+func TestMain(m *testing.M) {
+	// Never use the credentials of the user who runs the tests
+	os.Setenv(CredentialsFileEnvVar, filepath.Join(os.TempDir(), "atlas-upload-test-missing.json"))
+	os.Exit(m.Run())
+}
+
 func tempFile(t *testing.T) string {
 	tf, err := ioutil.TempFile("", "test")
 	if err != nil {
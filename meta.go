@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hashicorp/atlas-go/v1"
+	"github.com/hashicorp/atlas-upload-cli/archive"
+	"github.com/hashicorp/atlas-upload-cli/upload"
+	"github.com/hashicorp/logutils"
+	"github.com/mitchellh/ioprogress"
+)
+
+// Meta contains the state and the options that are shared by all of the
+// commands.
+type Meta struct {
+	// outStream and errStream are the standard out and standard error streams
+	// to write messages from the command.
+	outStream, errStream io.Writer
+
+	// inStream is the standard input, which prompts are read from.
+	inStream io.Reader
+
+	// address and token are the Atlas server address and API token given
+	// with -address and -token.
+	address, token string
+
+	// verify is set with -verify. See verifyFlags.
+	verify bool
+
+	// debug turns on debug output.
+	debug bool
+
+	// dryRun and showExcluded are set with -dry-run and -show-excluded. See
+	// listArchive.
+	dryRun, showExcluded bool
+
+	// format is the output format given with -format, either "text" or
+	// "json".
+	format string
+
+	// configPath and noConfig are set with -config and -no-config. See
+	// loadConfig.
+	configPath string
+	noConfig   bool
+}
+
+// flagSet returns a new FlagSet for the command with the given name. The
+// usage of the FlagSet prints the given help text, and the -debug flag is
+// registered on it.
+func (m *Meta) flagSet(name, help string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(m.errStream)
+	flags.Usage = func() {
+		fmt.Fprintf(m.errStream, "%s\n", strings.TrimSpace(help))
+	}
+	flags.BoolVar(&m.debug, "debug", false,
+		"turn on debug output")
+	return flags
+}
+
+// clientFlags registers the flags that are needed to talk to Atlas on the
+// given FlagSet.
+func (m *Meta) clientFlags(flags *flag.FlagSet) {
+	flags.StringVar(&m.address, "address", "",
+		"Atlas server address")
+	flags.StringVar(&m.token, "token", "",
+		"Atlas API token")
+}
+
+// verifyFlags registers the flag that turns off checking the token before
+// anything is archived on the given FlagSet. Only the commands that upload
+// register it; the client of the other commands is never verified.
+func (m *Meta) verifyFlags(flags *flag.FlagSet) {
+	flags.BoolVar(&m.verify, "verify", true,
+		"check the token before archiving")
+}
+
+// configFlags registers the flags that select the project config file on the
+// given FlagSet.
+func (m *Meta) configFlags(flags *flag.FlagSet) {
+	flags.StringVar(&m.configPath, "config", "",
+		"path to the config file")
+	flags.BoolVar(&m.noConfig, "no-config", false,
+		"do not load a config file")
+}
+
+// formatFlags registers the flag that selects the output format on the given
+// FlagSet.
+func (m *Meta) formatFlags(flags *flag.FlagSet) {
+	flags.StringVar(&m.format, "format", formatText,
+		"output format, text or json")
+}
+
+// retryFlags registers the flags that control how failed requests are
+// retried on the given FlagSet.
+func (m *Meta) retryFlags(flags *flag.FlagSet, opts *upload.RetryOpts) {
+	flags.IntVar(&opts.Attempts, "retry-attempts", 3,
+		"maximum number of attempts for each request")
+	flags.DurationVar(&opts.Backoff, "retry-backoff", time.Second,
+		"wait before the first retry, doubled after every retry")
+	flags.DurationVar(&opts.MaxWait, "retry-max-wait", 30*time.Second,
+		"maximum wait between two attempts")
+}
+
+// archiveFlags registers the flags that control which files are archived on
+// the given FlagSet.
+func (m *Meta) archiveFlags(flags *flag.FlagSet, opts *archive.ArchiveOpts) {
+	flags.BoolVar(&opts.VCS, "vcs", false,
+		"Uses VCS to determine files to exclude and include")
+	flags.Var((*FlagSliceVar)(&opts.Exclude), "exclude",
+		"files/folders to exclude")
+	flags.Var((*FlagSliceVar)(&opts.Include), "include",
+		"files/folders to include")
+	flags.BoolVar(&opts.LegacyGlobs, "legacy-globs", false,
+		"match -exclude and -include like older versions, without **")
+	flags.BoolVar(&opts.NoIgnoreFile, "no-ignore-file", false,
+		"do not read the .atlasignore file")
+	flags.BoolVar(&opts.NestedIgnoreFiles, "nested-ignore-files", false,
+		"also read the .atlasignore files in subdirectories")
+	flags.BoolVar(&opts.NoValidation, "no-validation", false,
+		"upload a path that is an archive without checking it")
+	flags.BoolVar(&opts.Reproducible, "reproducible", false,
+		"create the same archive byte for byte for the same files")
+	flags.Var(&FlagCompressionLevelVar{Level: &opts.CompressionLevel}, "compression-level",
+		"compression level, from 0 (store only) to 9")
+	flags.StringVar(&opts.TempDir, "temp-dir", "",
+		"directory to write the archive to before uploading it")
+	flags.BoolVar(&m.dryRun, "dry-run", false,
+		"list the files that would be archived without archiving them")
+	flags.BoolVar(&m.showExcluded, "show-excluded", false,
+		"also list the excluded files with -dry-run")
+}
+
+// archiveFormatFlags registers the flag that chooses the format of the
+// archive on the given FlagSet. It is only registered by the commands whose
+// archive need not be a gzipped tar.
+func (m *Meta) archiveFormatFlags(flags *flag.FlagSet, opts *archive.ArchiveOpts) {
+	flags.StringVar(&opts.Format, "archive-format", "",
+		"format of the archive: "+strings.Join(archive.Formats, ", "))
+}
+
+// parseFlags parses the given arguments and applies the shared flags, such
+// as turning on debug output.
+func (m *Meta) parseFlags(flags *flag.FlagSet, args []string) error {
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	// Turn on debug mode if requested
+	if m.debug {
+		levelFilter.SetMinLevel(logutils.LogLevel("DEBUG"))
+	}
+
+	return nil
+}
+
+// loadConfig loads the project config file for the given path to archive and
+// applies it to the flags that were not given on the command line, and to
+// the archive options if they are not nil. The loaded config is returned; it
+// is nil if there is no config file or -no-config was given.
+func (m *Meta) loadConfig(flags *flag.FlagSet, path string, opts *archive.ArchiveOpts) (*Config, error) {
+	if m.noConfig {
+		return nil, nil
+	}
+
+	var config *Config
+	var err error
+	if m.configPath != "" {
+		config, err = LoadConfig(m.configPath)
+	} else {
+		config, err = FindConfig(path)
+	}
+	if err != nil || config == nil {
+		return nil, err
+	}
+
+	if err := config.apply(flags, opts); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// interruptContext returns a context that is canceled when the process is
+// interrupted with SIGINT or SIGTERM. After the first signal the default
+// handling is restored, so a second signal terminates the process right
+// away. The returned function must be called to stop listening for signals.
+func (m *Meta) interruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		defer signal.Stop(sigCh)
+
+		select {
+		case sig := <-sigCh:
+			log.Printf("[INFO] received %s, canceling", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, cancel
+}
+
+// interrupted reports that the given operation was interrupted and returns
+// the exit code for it. Anything that was in flight has been canceled and
+// the temporary archive is removed.
+func (m *Meta) interrupted(operation string) int {
+	return m.fail(ExitCodeInterrupted,
+		"Interrupted while %s, canceled and cleaned up", operation)
+}
+
+// progressFunc returns a function that draws a progress bar with the given
+// label to the output stream, for use as an upload.Uploader ProgressFunc.
+// The bar is redrawn at most once per second and when the upload is done.
+// A negative total means that the size is not known yet.
+//
+// With -format=json nothing may be drawn in between the JSON output, so nil
+// is returned.
+func (m *Meta) progressFunc(label string) func(current, total int64) {
+	if m.format == formatJSON {
+		return nil
+	}
+
+	draw := ioprogress.DrawTerminalf(m.outStream, func(p, t int64) string {
+		text := ioprogress.DrawTextFormatBytes(p, t)
+		if t < 0 {
+			text = strings.SplitN(text, "/", 2)[0]
+		}
+		return fmt.Sprintf("%s: %s", label, text)
+	})
+
+	var last time.Time
+	return func(current, total int64) {
+		// The total is unknown while an archive is streamed, so only the
+		// bytes that were sent are drawn until the end
+		done := total >= 0 && current >= total
+		if !done && time.Since(last) < time.Second {
+			return
+		}
+		last = time.Now()
+
+		draw(current, total)
+		if done {
+			draw(-1, -1)
+		}
+	}
+}
+
+// client returns the Atlas client for the address and token given with
+// -address and -token. If the command registered verifyFlags, the token is
+// checked with the server unless -verify=false was given.
+func (m *Meta) client() (*atlas.Client, error) {
+	return atlasClient(&UploadOpts{
+		URL:    m.address,
+		Token:  m.token,
+		Verify: m.verify,
+	})
+}
+
+// archiveHelp is the help text for the options registered by archiveFlags,
+// except for -vcs which each command documents itself.
+const archiveHelp = `  -exclude=<path>     Glob pattern of files or directories to exclude (this may
+                      be specified multiple times)
+  -include=<path>     Glob pattern of files/directories to include (this may be
+                      specified multiple times, any excludes will override
+                      conflicting includes)
+                      Patterns match the path relative to the root, "**"
+                      matches any number of directories (e.g. "**/*.log")
+  -legacy-globs       Match -exclude and -include the way older versions did,
+                      without support for "**"
+  -no-ignore-file     Do not leave out the paths listed in the .atlasignore
+                      file in the root of the path
+  -nested-ignore-files
+                      Also read the .atlasignore files in the directories
+                      below the root; their patterns are relative to the
+                      directory they are in
+  -no-validation      Do not check a path that is already an archive before
+                      it is uploaded as-is; otherwise it is read to the end
+                      and rejected if it is truncated or corrupt, is not a
+                      tar or zip archive, or has entries with absolute paths
+                      or ".." in their path
+  -reproducible       Create the same archive byte for byte for the same
+                      files: every entry gets the modification time from
+                      SOURCE_DATE_EPOCH (or the Unix epoch), root ownership
+                      and 0644 or 0755 permissions
+  -compression-level=<n>
+                      Compression level of the archive, from 1 (fastest) to
+                      9 (smallest), or 0 to store the files without
+                      compressing them (defaults to 6); a tar.gz archive is
+                      compressed on all cores either way, a tar.zst archive
+                      can't be stored and a tar.xz archive has no levels
+  -temp-dir=<dir>     Directory to write the archive of a directory to before
+                      it is uploaded, instead of the system temporary
+                      directory; it must have enough free space for the
+                      archive
+  -dry-run            List every file that would be archived, with the total
+                      size and file count, without archiving or uploading
+                      anything
+  -show-excluded      With -dry-run, also list every excluded file or
+                      directory and the rule that excluded it
+`
+
+// archiveFormatHelp is the help text for the option registered by
+// archiveFormatFlags.
+const archiveFormatHelp = `  -archive-format=<format>
+                      Format of the archive of a directory: "tar.gz" (the
+                      default), "tar.zst", "tar.xz" or "zip"; a path that is
+                      already a tar, compressed or not, is passed through
+                      as-is, and so is a zip with -archive-format=zip
+`
+
+// formatHelp is the help text for the option registered by formatFlags.
+const formatHelp = `  -format=<format>    Output format, "text" (the default) or "json"; with
+                      "json" the result, or the error with its exit code and
+                      category, is printed as a single JSON document to stdout
+`
+
+// retryHelp is the help text for the options registered by retryFlags.
+const retryHelp = `  -retry-attempts=<n> Maximum number of attempts for each request to Atlas
+                      that fails with a server error, a connection reset or a
+                      timeout, except for creating a version, which is only
+                      retried if the connection was refused or the server
+                      asked to retry; 1 disables retries (defaults to 3)
+  -retry-backoff=<d>  How long to wait before the first retry; the wait is
+                      doubled after every retry (defaults to 1s)
+  -retry-max-wait=<d> Maximum wait between two attempts (defaults to 30s)
+`
+
+// configHelp is the help text for the options registered by configFlags.
+const configHelp = `  -config=<file>      Load the default options from the given config file
+                      instead of .atlas-upload.hcl or .atlas-upload.json in
+                      the path or the current directory
+  -no-config          Do not load a config file
+`
+
+// clientHelp is the help text for the options registered by clientFlags.
+const clientHelp = `  -address=<url>      The address of the Atlas server
+  -token=<token>      The Atlas API token
+`
+
+// verifyHelp is the help text for the option registered by verifyFlags.
+const verifyHelp = `  -verify=false       Do not check that the server can be reached and that the
+                      token is valid before archiving; errors then only show
+                      up when uploading
+`
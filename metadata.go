@@ -0,0 +1,33 @@
+package main
+
+import (
+	"log"
+)
+
+// mergeMetadata combines the user-supplied metadata with the metadata that was
+// detected from the VCS when the archive was created. The VCS keys are added
+// with the given prefix (for example "vcs." turns "commit" into "vcs.commit").
+//
+// Metadata given explicitly by the user always takes precedence: a VCS key is
+// never allowed to override a key that was set with -metadata. If there is no
+// metadata at all, nil is returned so that no metadata is sent with the
+// request.
+func mergeMetadata(user map[string]interface{}, vcs map[string]string, prefix string) map[string]interface{} {
+	if len(user) == 0 && len(vcs) == 0 {
+		return nil
+	}
+
+	result := make(map[string]interface{}, len(user)+len(vcs))
+	for k, v := range vcs {
+		result[prefix+k] = v
+	}
+
+	for k, v := range user {
+		if _, ok := result[k]; ok {
+			log.Printf("[DEBUG] metadata: %q overrides the VCS value", k)
+		}
+		result[k] = v
+	}
+
+	return result
+}
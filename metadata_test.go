@@ -0,0 +1,58 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeMetadata_empty(t *testing.T) {
+	if m := mergeMetadata(nil, nil, "vcs."); m != nil {
+		t.Fatalf("expected nil, got %#v", m)
+	}
+}
+
+func TestMergeMetadata_vcsOnly(t *testing.T) {
+	vcs := map[string]string{
+		"branch":        "master",
+		"commit":        "abcd1234",
+		"remote.origin": "https://github.com/foo/bar.git",
+	}
+
+	m := mergeMetadata(nil, vcs, "")
+	expected := map[string]interface{}{
+		"branch":        "master",
+		"commit":        "abcd1234",
+		"remote.origin": "https://github.com/foo/bar.git",
+	}
+	if !reflect.DeepEqual(m, expected) {
+		t.Fatalf("expected %#v to be %#v", m, expected)
+	}
+}
+
+func TestMergeMetadata_prefix(t *testing.T) {
+	vcs := map[string]string{"commit": "abcd1234"}
+	user := map[string]interface{}{"foo": "bar"}
+
+	m := mergeMetadata(user, vcs, "vcs.")
+	expected := map[string]interface{}{
+		"foo":        "bar",
+		"vcs.commit": "abcd1234",
+	}
+	if !reflect.DeepEqual(m, expected) {
+		t.Fatalf("expected %#v to be %#v", m, expected)
+	}
+}
+
+func TestMergeMetadata_userWins(t *testing.T) {
+	vcs := map[string]string{"commit": "abcd1234", "branch": "master"}
+	user := map[string]interface{}{"commit": "override"}
+
+	m := mergeMetadata(user, vcs, "")
+	expected := map[string]interface{}{
+		"branch": "master",
+		"commit": "override",
+	}
+	if !reflect.DeepEqual(m, expected) {
+		t.Fatalf("expected %#v to be %#v", m, expected)
+	}
+}
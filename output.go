@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hashicorp/atlas-go/v1"
+)
+
+// Output formats that can be given with -format.
+const (
+	formatText = "text"
+	formatJSON = "json"
+)
+
+// uploadResult is the document that is printed for a successful upload with
+// -format=json.
+type uploadResult struct {
+	Slug     string                 `json:"slug"`
+	Version  uint64                 `json:"version"`
+	Size     int64                  `json:"size"`
+	SHA256   string                 `json:"sha256"`
+	MD5      string                 `json:"md5,omitempty"`
+	Files    int                    `json:"files"`
+	Format   string                 `json:"format"`
+	Metadata map[string]interface{} `json:"metadata"`
+	Elapsed  float64                `json:"elapsed_seconds"`
+	Server   string                 `json:"server"`
+	Created  bool                   `json:"created"`
+
+	// Entries and UncompressedSize describe an archive that was validated
+	// and uploaded as-is.
+	Entries          int   `json:"entries,omitempty"`
+	UncompressedSize int64 `json:"uncompressed_size,omitempty"`
+}
+
+// dryRunResult is the document that is printed for -dry-run with
+// -format=json. The path of a directory entry has a trailing slash.
+type dryRunResult struct {
+	Entries []dryRunEntry `json:"entries"`
+	Files   int           `json:"files"`
+	Size    int64         `json:"size"`
+}
+
+type dryRunEntry struct {
+	Path     string `json:"path"`
+	Size     int64  `json:"size"`
+	Excluded bool   `json:"excluded"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// artifactResult is the document that is printed for a successful artifact
+// upload with -format=json.
+type artifactResult struct {
+	Slug     string            `json:"slug"`
+	Type     string            `json:"type"`
+	ID       string            `json:"id,omitempty"`
+	Version  int               `json:"version"`
+	Size     int64             `json:"size"`
+	SHA256   string            `json:"sha256,omitempty"`
+	Metadata map[string]string `json:"metadata"`
+	Elapsed  float64           `json:"elapsed_seconds"`
+	Server   string            `json:"server"`
+}
+
+// terraformResult is the document that is printed for a successful
+// Terraform configuration upload with -format=json. Variables are only the
+// keys of the variables, since their values may be secret.
+type terraformResult struct {
+	Slug      string            `json:"slug"`
+	Version   int               `json:"version"`
+	Size      int64             `json:"size"`
+	SHA256    string            `json:"sha256"`
+	Files     int               `json:"files"`
+	Metadata  map[string]string `json:"metadata"`
+	Remotes   []string          `json:"remotes"`
+	Variables []string          `json:"variables"`
+	Elapsed   float64           `json:"elapsed_seconds"`
+	Server    string            `json:"server"`
+}
+
+// buildConfigResult is the document that is printed for a successful build
+// configuration upload with -format=json.
+type buildConfigResult struct {
+	Slug     string                   `json:"slug"`
+	Builds   []atlas.BuildConfigBuild `json:"builds"`
+	Size     int64                    `json:"size"`
+	SHA256   string                   `json:"sha256"`
+	Files    int                      `json:"files"`
+	Metadata map[string]interface{}   `json:"metadata"`
+	Elapsed  float64                  `json:"elapsed_seconds"`
+	Server   string                   `json:"server"`
+}
+
+// errorResult is the document that is printed for an error with
+// -format=json.
+type errorResult struct {
+	Error    string `json:"error"`
+	ExitCode int    `json:"exit_code"`
+	Category string `json:"category"`
+}
+
+// exitCodeCategories are the error categories that are reported for each
+// exit code with -format=json.
+var exitCodeCategories = map[int]string{
+	ExitCodeError:           "error",
+	ExitCodeParseFlagsError: "parse_flags",
+	ExitCodeBadArgs:         "bad_args",
+	ExitCodeArchiveError:    "archive",
+	ExitCodeUploadError:     "upload",
+	ExitCodeInterrupted:     "interrupted",
+	ExitCodeAuthError:       "auth",
+	ExitCodeNotFoundError:   "not_found",
+	ExitCodeValidationError: "validation",
+	ExitCodeNetworkError:    "network",
+}
+
+// validFormat says whether the format given with -format is supported.
+func validFormat(format string) bool {
+	return format == formatText || format == formatJSON
+}
+
+// printJSON prints the value as an indented JSON document to the output
+// stream.
+func (m *Meta) printJSON(v interface{}) {
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		// This can't happen with the documents above, but make sure the
+		// caller still sees something.
+		fmt.Fprintf(m.errStream, "error encoding JSON: %s\n", err)
+		return
+	}
+
+	fmt.Fprintf(m.outStream, "%s\n", out)
+}
+
+// fail reports the error and returns the given exit code. With -format=json
+// the error is printed as a JSON document to the output stream, otherwise it
+// is printed as text to the error stream. Secrets are masked like they are in
+// the log output.
+func (m *Meta) fail(code int, format string, args ...interface{}) int {
+	msg := logRedactor.redact(fmt.Sprintf(format, args...))
+	if m.format != formatJSON {
+		fmt.Fprintf(m.errStream, "%s\n", msg)
+		return code
+	}
+
+	category, ok := exitCodeCategories[code]
+	if !ok {
+		category = exitCodeCategories[ExitCodeError]
+	}
+
+	m.printJSON(&errorResult{
+		Error:    msg,
+		ExitCode: code,
+		Category: category,
+	})
+	return code
+}
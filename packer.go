@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/hashicorp/atlas-go/v1"
+)
+
+// packerTemplate is the part of a Packer template that is needed to find
+// its builds.
+type packerTemplate struct {
+	Builders []struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+	} `json:"builders"`
+
+	// PostProcessors is a list of post-processors or sequences of them, and
+	// every post-processor is either a type or an object; see
+	// postProcessors.
+	PostProcessors []json.RawMessage `json:"post-processors"`
+}
+
+// packerPostProcessor is a single post-processor of a Packer template.
+type packerPostProcessor struct {
+	Type   string   `json:"type"`
+	Only   []string `json:"only"`
+	Except []string `json:"except"`
+}
+
+// skip says whether the post-processor doesn't run for the given build, the
+// same way Packer decides it.
+func (p *packerPostProcessor) skip(build string) bool {
+	if len(p.Only) > 0 {
+		return !containsString(p.Only, build)
+	}
+
+	return containsString(p.Except, build)
+}
+
+// packerBuilds reads the Packer template at the given path and returns its
+// builds. Like "packer push" does, a build has an artifact if an "atlas"
+// post-processor runs for it.
+func packerBuilds(path string) ([]atlas.BuildConfigBuild, error) {
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading template: %s", err)
+	}
+
+	var tpl packerTemplate
+	if err := json.Unmarshal(d, &tpl); err != nil {
+		return nil, fmt.Errorf("error parsing template %s: %s", path, err)
+	}
+
+	var pps []*packerPostProcessor
+	for _, raw := range tpl.PostProcessors {
+		p, err := postProcessors(raw)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing template %s: %s", path, err)
+		}
+		pps = append(pps, p...)
+	}
+
+	if len(tpl.Builders) == 0 {
+		return nil, fmt.Errorf("error parsing template %s: no builders", path)
+	}
+
+	builds := make([]atlas.BuildConfigBuild, 0, len(tpl.Builders))
+	seen := make(map[string]bool, len(tpl.Builders))
+	for _, b := range tpl.Builders {
+		if b.Type == "" {
+			return nil, fmt.Errorf("error parsing template %s: builder without a type", path)
+		}
+
+		// The name of a build defaults to the type of its builder
+		build := atlas.BuildConfigBuild{Name: b.Name, Type: b.Type}
+		if build.Name == "" {
+			build.Name = b.Type
+		}
+		if seen[build.Name] {
+			return nil, fmt.Errorf("error parsing template %s: duplicate build %q",
+				path, build.Name)
+		}
+		seen[build.Name] = true
+
+		for _, p := range pps {
+			if p.Type == "atlas" && !p.skip(build.Name) {
+				build.Artifact = true
+			}
+		}
+
+		builds = append(builds, build)
+	}
+
+	return builds, nil
+}
+
+// postProcessors decodes a single entry of the "post-processors" of a
+// template, which is a type, an object or a sequence of either.
+func postProcessors(raw json.RawMessage) ([]*packerPostProcessor, error) {
+	var list []json.RawMessage
+	if err := json.Unmarshal(raw, &list); err != nil {
+		p, err := postProcessor(raw)
+		if err != nil {
+			return nil, err
+		}
+
+		return []*packerPostProcessor{p}, nil
+	}
+
+	result := make([]*packerPostProcessor, 0, len(list))
+	for _, item := range list {
+		p, err := postProcessor(item)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, p)
+	}
+
+	return result, nil
+}
+
+// postProcessor decodes a single post-processor, which is either a type or
+// an object.
+func postProcessor(raw json.RawMessage) (*packerPostProcessor, error) {
+	var p packerPostProcessor
+	if err := json.Unmarshal(raw, &p.Type); err == nil {
+		return &p, nil
+	}
+
+	if err := json.Unmarshal(raw, &p); err != nil {
+		return nil, fmt.Errorf("invalid post-processor %s", raw)
+	}
+
+	return &p, nil
+}
+
+// containsString says whether the list contains the string.
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
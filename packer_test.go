@@ -0,0 +1,55 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/atlas-go/v1"
+)
+
+func TestPackerBuilds(t *testing.T) {
+	builds, err := packerBuilds(filepath.Join(testFixture("packer-dir"), "template.json"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := []atlas.BuildConfigBuild{
+		{Name: "amazon-ebs", Type: "amazon-ebs", Artifact: true},
+		{Name: "local", Type: "virtualbox-iso", Artifact: true},
+		{Name: "docker", Type: "docker", Artifact: false},
+	}
+	if !reflect.DeepEqual(builds, expected) {
+		t.Fatalf("expected %#v to be %#v", builds, expected)
+	}
+}
+
+func TestPackerBuilds_invalid(t *testing.T) {
+	cases := map[string]string{
+		"not json":       `{`,
+		"no builders":    `{"builders": []}`,
+		"no type":        `{"builders": [{"name": "a"}]}`,
+		"duplicate":      `{"builders": [{"type": "docker"}, {"type": "docker"}]}`,
+		"post-processor": `{"builders": [{"type": "docker"}], "post-processors": [1]}`,
+	}
+
+	dir, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for name, tpl := range cases {
+		path := filepath.Join(dir, strings.Replace(name, " ", "-", -1)+".json")
+		if err := ioutil.WriteFile(path, []byte(tpl), 0644); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		if _, err := packerBuilds(path); err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"regexp"
+	"sync"
+)
+
+// redacted is what secrets are replaced with.
+const redacted = "REDACTED"
+
+// minSecretLength is the length of the shortest known secret that is masked.
+// Shorter values would mask ordinary words, and are not real tokens anyway.
+const minSecretLength = 8
+
+// redactPatterns match the secrets that may show up in the debug output of
+// the Atlas client: the token headers of the requests, which are printed
+// with %#v, the tokens and upload paths in query parameters, and the same
+// fields in the JSON bodies of the responses.
+var redactPatterns = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	{
+		regexp.MustCompile(`(?i)("(?:X-Atlas-Token|Authorization)":\[\]string\{)[^}]*`),
+		`${1}"` + redacted + `"`,
+	},
+	{
+		regexp.MustCompile(`(?i)\b((?:X-Atlas-Token|Authorization):[ \t]*)[^\r\n"]+`),
+		"${1}" + redacted,
+	},
+	{
+		regexp.MustCompile(`\b((?:access_token|token|upload_path)=)[^&\s"#]+`),
+		"${1}" + redacted,
+	},
+	{
+		regexp.MustCompile(`("(?:token|upload_path)"\s*:\s*")(?:[^"\\]|\\.)*`),
+		"${1}" + redacted,
+	},
+}
+
+// uploadPathPattern matches the upload paths in the JSON bodies of the
+// responses, so that they can be masked wherever they show up later, such
+// as in the errors of the upload request.
+var uploadPathPattern = regexp.MustCompile(`"upload_path"\s*:\s*("(?:[^"\\]|\\.)*")`)
+
+// redactor is the writer that the log output goes through before it reaches
+// the error stream. It masks the known secrets, such as the API token that
+// is used, and everything that redactPatterns match. Upload paths that it
+// sees in responses are added to the known secrets.
+type redactor struct {
+	mu      sync.Mutex
+	w       io.Writer
+	secrets [][]byte
+}
+
+// logRedactor is the redactor that initLogger sets up for the log output.
+var logRedactor = &redactor{}
+
+// addSecret adds a value that must never be written, such as a token.
+func (r *redactor) addSecret(secret string) {
+	if len(secret) < minSecretLength {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, s := range r.secrets {
+		if string(s) == secret {
+			return
+		}
+	}
+	r.secrets = append(r.secrets, []byte(secret))
+}
+
+// redact returns the string with every secret masked.
+func (r *redactor) redact(s string) string {
+	return string(r.redactBytes([]byte(s)))
+}
+
+func (r *redactor) redactBytes(p []byte) []byte {
+	for _, m := range uploadPathPattern.FindAllSubmatch(p, -1) {
+		var path string
+		if err := json.Unmarshal(m[1], &path); err == nil {
+			r.addSecret(path)
+		}
+	}
+
+	r.mu.Lock()
+	for _, s := range r.secrets {
+		p = bytes.Replace(p, s, []byte(redacted), -1)
+	}
+	r.mu.Unlock()
+
+	for _, pattern := range redactPatterns {
+		p = pattern.re.ReplaceAll(p, []byte(pattern.repl))
+	}
+
+	return p
+}
+
+func (r *redactor) Write(p []byte) (int, error) {
+	if _, err := r.w.Write(r.redactBytes(p)); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
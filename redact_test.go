@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRedactor(t *testing.T) {
+	cases := []struct {
+		in, out string
+	}{
+		{
+			`[DEBUG] raw request: &http.Request{Header:http.Header{"X-Atlas-Token":[]string{"abc.atlasv1.xyz"}}}`,
+			`[DEBUG] raw request: &http.Request{Header:http.Header{"X-Atlas-Token":[]string{"REDACTED"}}}`,
+		},
+		{
+			`[DEBUG] Authorization: Bearer abc.atlasv1.xyz`,
+			`[DEBUG] Authorization: REDACTED`,
+		},
+		{
+			`[INFO] putting file: https://binstore.example.com/upload?token=abc&size=3`,
+			`[INFO] putting file: https://binstore.example.com/upload?token=REDACTED&size=3`,
+		},
+		{
+			`[DEBUG] URL:(*url.URL){RawQuery:"upload_path=https%3A%2F%2Fbinstore"}`,
+			`[DEBUG] URL:(*url.URL){RawQuery:"upload_path=REDACTED"}`,
+		},
+		{
+			`[DEBUG] response: {"token": "abc.atlasv1.xyz"}`,
+			`[DEBUG] response: {"token": "REDACTED"}`,
+		},
+		{
+			`[INFO] getting application hashicorp/project`,
+			`[INFO] getting application hashicorp/project`,
+		},
+	}
+
+	for _, tc := range cases {
+		r := &redactor{}
+		if out := r.redact(tc.in); out != tc.out {
+			t.Errorf("expected %q to be %q", out, tc.out)
+		}
+	}
+}
+
+func TestRedactor_secrets(t *testing.T) {
+	var buf bytes.Buffer
+	r := &redactor{w: &buf}
+	r.addSecret("my-secret-token")
+	r.addSecret("short")
+
+	// Upload paths that were seen are masked from then on
+	r.Write([]byte(`[DEBUG] response: {"upload_path": "https:\/\/binstore.example.com\/f00d", "version": 2}` + "\n"))
+	r.Write([]byte("[INFO] putting file: https://binstore.example.com/f00d\n"))
+	r.Write([]byte("[DEBUG] using my-secret-token, which is not short\n"))
+
+	out := buf.String()
+	for _, s := range []string{"f00d", "my-secret-token"} {
+		if strings.Contains(out, s) {
+			t.Errorf("expected %q not to contain %q", out, s)
+		}
+	}
+	if !strings.Contains(out, "not short") {
+		t.Errorf("expected %q to contain %q", out, "not short")
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// prompter reads the answers to prompts from the input stream, which is
+// usually the terminal, and writes the prompts to the output stream.
+type prompter struct {
+	in  *bufio.Reader
+	f   *os.File
+	out io.Writer
+}
+
+func newPrompter(in io.Reader, out io.Writer) *prompter {
+	f, _ := in.(*os.File)
+	return &prompter{in: bufio.NewReader(in), f: f, out: out}
+}
+
+// terminal says whether the input stream is a terminal.
+func (p *prompter) terminal() bool {
+	if p.f == nil {
+		return false
+	}
+
+	fi, err := p.f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// ask prints the prompt and returns the line that was entered.
+func (p *prompter) ask(prompt string) (string, error) {
+	fmt.Fprint(p.out, prompt)
+	return p.readLine()
+}
+
+// askSecret prints the prompt and returns the line that was entered, without
+// echoing it if the input is a terminal. If the input is not a terminal,
+// such as when the secret is piped in, it is read as-is.
+func (p *prompter) askSecret(prompt string) (string, error) {
+	fmt.Fprint(p.out, prompt)
+	if !p.terminal() {
+		return p.readLine()
+	}
+
+	restore, err := disableEcho(p.f.Fd())
+	if err != nil {
+		return "", fmt.Errorf("error turning off echo: %s", err)
+	}
+
+	// Don't leave the terminal without echo if we are interrupted
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-sigCh:
+			restore()
+			fmt.Fprintln(p.out)
+			os.Exit(ExitCodeInterrupted)
+		case <-done:
+		}
+	}()
+
+	line, err := p.readLine()
+	signal.Stop(sigCh)
+	close(done)
+	restore()
+
+	// The newline that was entered was not echoed
+	fmt.Fprintln(p.out)
+	return line, err
+}
+
+func (p *prompter) readLine() (string, error) {
+	line, err := p.in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
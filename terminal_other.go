@@ -0,0 +1,15 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !windows
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!windows
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// disableEcho is not supported on this platform, so passwords can only be
+// piped to the standard input.
+func disableEcho(fd uintptr) (func(), error) {
+	return nil, fmt.Errorf("turning off echo is not supported on %s", runtime.GOOS)
+}
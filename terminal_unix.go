@@ -0,0 +1,40 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// disableEcho turns off the echo of the terminal with the given file
+// descriptor, and returns the function that turns it back on.
+func disableEcho(fd uintptr) (func(), error) {
+	var old syscall.Termios
+	if err := ioctlTermios(fd, ioctlReadTermios, &old); err != nil {
+		return nil, err
+	}
+
+	t := old
+	t.Lflag &^= syscall.ECHO
+	t.Lflag |= syscall.ICANON | syscall.ISIG
+	t.Iflag |= syscall.ICRNL
+	if err := ioctlTermios(fd, ioctlWriteTermios, &t); err != nil {
+		return nil, err
+	}
+
+	return func() {
+		ioctlTermios(fd, ioctlWriteTermios, &old)
+	}, nil
+}
+
+func ioctlTermios(fd, req uintptr, t *syscall.Termios) error {
+	_, _, errno := syscall.Syscall6(syscall.SYS_IOCTL, fd, req,
+		uintptr(unsafe.Pointer(t)), 0, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"syscall"
+)
+
+// Console input modes, see
+// https://docs.microsoft.com/en-us/windows/console/setconsolemode.
+const (
+	enableProcessedInput = 0x1
+	enableLineInput      = 0x2
+	enableEchoInput      = 0x4
+)
+
+var procSetConsoleMode = syscall.NewLazyDLL("kernel32.dll").NewProc("SetConsoleMode")
+
+// disableEcho turns off the echo of the console with the given handle, and
+// returns the function that turns it back on.
+func disableEcho(fd uintptr) (func(), error) {
+	var old uint32
+	if err := syscall.GetConsoleMode(syscall.Handle(fd), &old); err != nil {
+		return nil, err
+	}
+
+	mode := old&^enableEchoInput | enableLineInput | enableProcessedInput
+	if r, _, err := procSetConsoleMode.Call(fd, uintptr(mode)); r == 0 {
+		return nil, err
+	}
+
+	return func() {
+		procSetConsoleMode.Call(fd, uintptr(old))
+	}, nil
+}
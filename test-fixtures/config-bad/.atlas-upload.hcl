@@ -0,0 +1,2 @@
+slug     = "hashicorp/project"
+excludes = ["*.log"]
@@ -0,0 +1,11 @@
+slug    = "hashicorp/project"
+exclude = ["*.log"]
+
+metadata {
+  team = "ops"
+  foo  = "config"
+}
+
+extra {
+  "VERSION" = "../archive-dir/foo.txt"
+}
@@ -1,8 +1,7 @@
 package main
 
 import (
-	"fmt"
-	"io"
+	"log"
 
 	"github.com/hashicorp/atlas-go/v1"
 )
@@ -22,61 +21,17 @@ type UploadOpts struct {
 
 	// Metadata is the arbitrary metadata to upload with this application.
 	Metadata map[string]interface{}
-}
-
-// Upload uploads the reader, representing a single archive, to the
-// application given by UploadOpts.
-//
-// The Upload happens async and the return values are the done channel,
-// the error channel, and then an error that can happen during initialization.
-// If error is returned, then the channels will be nil and the upload never
-// started. Otherwise, the upload has started in the background and is not
-// done until the done channel or error channel send a value. Once either send
-// a value, the upload is stopped.
-func Upload(r io.Reader, size int64, opts *UploadOpts) (<-chan uint64, <-chan error, error) {
-	// Create the client
-	client, err := atlasClient(opts)
-	if err != nil {
-		return nil, nil, fmt.Errorf("upload: %s", err)
-	}
-
-	// Separate the slug into the user and name components
-	user, name, err := atlas.ParseSlug(opts.Slug)
-	if err != nil {
-		return nil, nil, fmt.Errorf("upload: %s", err)
-	}
-
-	// Get the app
-	app, err := client.App(user, name)
-	if err != nil {
-		if err == atlas.ErrNotFound {
-			// Application doesn't exist, attempt to create it
-			app, err = client.CreateApp(user, name)
-		}
-
-		if err != nil {
-			return nil, nil, fmt.Errorf("upload: %s", err)
-		}
-	}
 
-	doneCh, errCh := make(chan uint64), make(chan error)
-
-	// Start the upload
-	go func() {
-		vsn, err := client.UploadApp(app, opts.Metadata, r, size)
-		if err != nil {
-			errCh <- err
-			return
-		}
-
-		doneCh <- vsn
-	}()
-
-	return doneCh, errCh, nil
+	// Verify, if true, checks that the server can be reached and that the
+	// token is valid when the client is created, so that a bad token fails
+	// before anything is archived.
+	Verify bool
 }
 
 // Create the client - if a URL is given, construct a new Client from the URL,
-// but if not URL is given, use the default client.
+// but if not URL is given, use the default client. If no token is given and
+// ATLAS_TOKEN is not set, the token that "login" saved for the server is
+// used. The token is checked with the server if opts.Verify is set.
 func atlasClient(opts *UploadOpts) (*atlas.Client, error) {
 	var client *atlas.Client
 	var err error
@@ -86,10 +41,28 @@ func atlasClient(opts *UploadOpts) (*atlas.Client, error) {
 	} else {
 		client, err = atlas.NewClient(opts.URL)
 	}
+	if err != nil {
+		return nil, err
+	}
 
 	if opts.Token != "" {
 		client.Token = opts.Token
+	} else if client.Token == "" {
+		token, err := credentialsToken(client.URL)
+		if err != nil {
+			log.Printf("[WARN] %s", err)
+		}
+		client.Token = token
+	}
+
+	// Keep the token out of the debug output
+	logRedactor.addSecret(client.Token)
+
+	if opts.Verify {
+		if err := client.Verify(); err != nil {
+			return nil, err
+		}
 	}
 
-	return client, err
+	return client, nil
 }
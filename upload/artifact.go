@@ -0,0 +1,137 @@
+package upload
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/atlas-go/v1"
+)
+
+// ArtifactOpts are the options for uploading a single artifact version.
+type ArtifactOpts struct {
+	// Slug is the "user/name" of the artifact to upload.
+	Slug string
+
+	// Type is the type of the artifact, such as "amazon.image" or
+	// "vagrant.box".
+	Type string
+
+	// ID is the ID of the artifact within its type, such as the AMI ID of
+	// an image. It may be empty if the artifact is a file.
+	ID string
+
+	// Metadata is the arbitrary metadata to upload with this version.
+	Metadata map[string]string
+
+	// BuildID and CompileID are the IDs of the Atlas build and compile that
+	// produced the artifact, if any.
+	BuildID   int
+	CompileID int
+
+	// SHA256 and DigestHeaders are like Opts.SHA256 and Opts.DigestHeaders,
+	// for the artifact file.
+	SHA256        string
+	DigestHeaders bool
+}
+
+// ArtifactResult is the result of a successful artifact upload.
+type ArtifactResult struct {
+	// Version is the version number of the artifact that was created, and
+	// Slug is its full slug as reported by Atlas.
+	Version int
+	Slug    string
+
+	// Size is the size of the file in bytes, and Checksum is its
+	// hex-encoded SHA-256 checksum. Both are empty if there was no file.
+	Size     int64
+	Checksum string
+
+	// Duration and Metadata are like Result.Duration and Result.Metadata.
+	Duration time.Duration
+	Metadata map[string]string
+}
+
+// UploadArtifact creates a new version of the artifact given by the options
+// and uploads the file of the given size to it. The reader may be nil for
+// artifacts that have no file, such as images that are stored elsewhere. If
+// the artifact does not exist, it is created.
+//
+// Requests are retried and canceled like they are for Upload.
+func (u *Uploader) UploadArtifact(ctx context.Context, r io.ReadSeeker, size int64, opts *ArtifactOpts) (*ArtifactResult, error) {
+	start := time.Now()
+
+	user, name, err := atlas.ParseSlug(opts.Slug)
+	if err != nil {
+		return nil, fmt.Errorf("upload: %s", err)
+	}
+	if opts.Type == "" {
+		return nil, fmt.Errorf("upload: artifact type must be set")
+	}
+
+	// Get the artifact, creating it if it doesn't exist
+	err = u.getOrCreate(ctx, "getting artifact", func() error {
+		_, err := getArtifact(ctx, u.Client, user, name)
+		return err
+	}, func() error {
+		_, err := u.Client.CreateArtifact(user, name)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := make(map[string]string, len(opts.Metadata)+1)
+	for k, v := range opts.Metadata {
+		metadata[k] = v
+	}
+
+	var sum string
+	var header http.Header
+	if r != nil {
+		sum, header, err = archiveChecksum(r, opts.SHA256, "", opts.DigestHeaders)
+		if err != nil {
+			return nil, err
+		}
+		metadata[SHA256Key] = sum
+	} else {
+		size = 0
+	}
+
+	log.Printf("[INFO] uploading artifact %s/%s (%s, %d bytes) with metadata %q",
+		user, name, opts.Type, size, metadata)
+
+	var av *atlas.ArtifactVersion
+	err = u.sendArchive(ctx, "creating artifact version", func() (string, error) {
+		var err error
+		av, err = createArtifactVersion(ctx, u.Client, &atlas.UploadArtifactOpts{
+			User:      user,
+			Name:      name,
+			Type:      opts.Type,
+			ID:        opts.ID,
+			File:      r,
+			Metadata:  metadata,
+			BuildID:   opts.BuildID,
+			CompileID: opts.CompileID,
+		})
+		if err != nil {
+			return "", err
+		}
+		return av.UploadPath, nil
+	}, r, size, sum, header)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ArtifactResult{
+		Version:  av.Version,
+		Slug:     av.Slug,
+		Size:     size,
+		Checksum: sum,
+		Duration: time.Since(start),
+		Metadata: metadata,
+	}, nil
+}
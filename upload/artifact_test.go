@@ -0,0 +1,124 @@
+package upload
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/atlas-go/v1"
+)
+
+// testArtifactServer returns a server that implements the Atlas endpoints
+// that are needed to upload the "hashicorp/image" artifact. The body of the
+// request that creates the version is decoded into version, and the file is
+// read into body.
+func testArtifactServer(t *testing.T, exists bool, version *map[string]interface{}, body *[]byte) (*httptest.Server, *atlas.Client) {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+
+	mux.HandleFunc("/api/v1/artifacts/hashicorp/image", func(w http.ResponseWriter, r *http.Request) {
+		if !exists {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{"artifact": {"username": "hashicorp", "name": "image"}}`)
+	})
+	mux.HandleFunc("/api/v1/artifacts", func(w http.ResponseWriter, r *http.Request) {
+		exists = true
+		fmt.Fprint(w, `{"artifact": {"username": "hashicorp", "name": "image"}}`)
+	})
+	mux.HandleFunc("/api/v1/artifacts/hashicorp/image/vagrant.box", func(w http.ResponseWriter, r *http.Request) {
+		var v struct {
+			Version map[string]interface{} `json:"artifact_version"`
+		}
+		json.NewDecoder(r.Body).Decode(&v)
+		*version = v.Version
+
+		fmt.Fprintf(w, `{"upload_path": "%s/upload", "version": 3, "slug": "hashicorp/image/vagrant.box/3"}`,
+			server.URL)
+	})
+	mux.HandleFunc("/upload", func(w http.ResponseWriter, r *http.Request) {
+		*body, _ = ioutil.ReadAll(r.Body)
+	})
+
+	client, err := atlas.NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return server, client
+}
+
+func TestUploader_UploadArtifact(t *testing.T) {
+	var version map[string]interface{}
+	var body []byte
+	server, client := testArtifactServer(t, false, &version, &body)
+	defer server.Close()
+
+	uploader := &Uploader{Client: client}
+	data := "box data"
+	result, err := uploader.UploadArtifact(context.Background(),
+		strings.NewReader(data), int64(len(data)), &ArtifactOpts{
+			Slug:     "hashicorp/image",
+			Type:     "vagrant.box",
+			Metadata: map[string]string{"provider": "virtualbox"},
+			BuildID:  12,
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Version != 3 || result.Slug != "hashicorp/image/vagrant.box/3" {
+		t.Fatalf("bad result: %#v", result)
+	}
+	if string(body) != data {
+		t.Fatalf("expected %q to be %q", body, data)
+	}
+
+	if version["file"] != true || version["build_id"] != float64(12) {
+		t.Fatalf("bad version: %#v", version)
+	}
+	metadata := version["metadata"].(map[string]interface{})
+	if metadata["provider"] != "virtualbox" || metadata[SHA256Key] != result.Checksum {
+		t.Fatalf("bad metadata: %#v", metadata)
+	}
+}
+
+func TestUploader_UploadArtifact_noFile(t *testing.T) {
+	var version map[string]interface{}
+	var body []byte
+	server, client := testArtifactServer(t, true, &version, &body)
+	defer server.Close()
+
+	uploader := &Uploader{Client: client}
+	result, err := uploader.UploadArtifact(context.Background(), nil, 0, &ArtifactOpts{
+		Slug: "hashicorp/image",
+		Type: "vagrant.box",
+		ID:   "ami-1234",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Checksum != "" || body != nil {
+		t.Fatalf("expected no file to be uploaded, got %#v", result)
+	}
+	if version["file"] != false || version["id"] != "ami-1234" {
+		t.Fatalf("bad version: %#v", version)
+	}
+}
+
+func TestUploader_UploadArtifact_noType(t *testing.T) {
+	uploader := &Uploader{}
+	_, err := uploader.UploadArtifact(context.Background(), nil, 0, &ArtifactOpts{
+		Slug: "hashicorp/image",
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
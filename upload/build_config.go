@@ -0,0 +1,95 @@
+package upload
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/hashicorp/atlas-go/v1"
+)
+
+// BuildConfigOpts are the options for uploading a single Packer build
+// configuration version.
+type BuildConfigOpts struct {
+	// Slug is the "user/name" of the build configuration to upload.
+	Slug string
+
+	// Builds are the builds of the template that is uploaded.
+	Builds []atlas.BuildConfigBuild
+
+	// Metadata is the arbitrary metadata to upload with this version.
+	Metadata map[string]interface{}
+
+	// SHA256 and DigestHeaders are like Opts.SHA256 and Opts.DigestHeaders.
+	SHA256        string
+	DigestHeaders bool
+}
+
+// BuildConfigResult is the result of a successful build configuration
+// upload. Atlas doesn't report the number of the new version.
+type BuildConfigResult struct {
+	// Size is the size of the archive in bytes, and Checksum is its
+	// hex-encoded SHA-256 checksum.
+	Size     int64
+	Checksum string
+
+	// Duration and Metadata are like Result.Duration and Result.Metadata.
+	Duration time.Duration
+	Metadata map[string]interface{}
+}
+
+// UploadBuildConfig creates a new version of the build configuration given
+// by the options and uploads the archive of the given size to it. If the
+// build configuration does not exist, it is created.
+//
+// Requests are retried and canceled like they are for Upload.
+func (u *Uploader) UploadBuildConfig(ctx context.Context, r io.ReadSeeker, size int64, opts *BuildConfigOpts) (*BuildConfigResult, error) {
+	start := time.Now()
+
+	user, name, err := atlas.ParseSlug(opts.Slug)
+	if err != nil {
+		return nil, fmt.Errorf("upload: %s", err)
+	}
+
+	// Get the build configuration, creating it if it doesn't exist
+	err = u.getOrCreate(ctx, "getting build configuration", func() error {
+		_, err := getBuildConfig(ctx, u.Client, user, name)
+		return err
+	}, func() error {
+		_, err := u.Client.CreateBuildConfig(user, name)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sum, header, err := archiveChecksum(r, opts.SHA256, "", opts.DigestHeaders)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := make(map[string]interface{}, len(opts.Metadata)+1)
+	for k, v := range opts.Metadata {
+		metadata[k] = v
+	}
+	metadata[SHA256Key] = sum
+
+	log.Printf("[INFO] uploading build configuration %s/%s (%d bytes) with metadata %q",
+		user, name, size, metadata)
+
+	err = u.sendArchive(ctx, "creating build configuration version", func() (string, error) {
+		return createBuildConfigVersion(ctx, u.Client, user, name, opts.Builds, metadata)
+	}, r, size, sum, header)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BuildConfigResult{
+		Size:     size,
+		Checksum: sum,
+		Duration: time.Since(start),
+		Metadata: metadata,
+	}, nil
+}
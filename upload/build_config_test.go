@@ -0,0 +1,83 @@
+package upload
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/atlas-go/v1"
+)
+
+func TestUploader_UploadBuildConfig(t *testing.T) {
+	var created bool
+	var version struct {
+		Metadata map[string]interface{}   `json:"metadata"`
+		Builds   []atlas.BuildConfigBuild `json:"builds"`
+	}
+	var body []byte
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	mux.HandleFunc("/api/v1/packer/build-configurations/hashicorp/web", func(w http.ResponseWriter, r *http.Request) {
+		if !created {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{"username": "hashicorp", "name": "web"}`)
+	})
+	mux.HandleFunc("/api/v1/packer/build-configurations", func(w http.ResponseWriter, r *http.Request) {
+		created = true
+		fmt.Fprint(w, `{"username": "hashicorp", "name": "web"}`)
+	})
+	mux.HandleFunc("/api/v1/packer/build-configurations/hashicorp/web/versions", func(w http.ResponseWriter, r *http.Request) {
+		var wrapper struct {
+			Version interface{} `json:"version"`
+		}
+		wrapper.Version = &version
+		json.NewDecoder(r.Body).Decode(&wrapper)
+
+		fmt.Fprintf(w, `{"upload_path": "%s/upload"}`, server.URL)
+	})
+	mux.HandleFunc("/upload", func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+	})
+
+	client, err := atlas.NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	builds := []atlas.BuildConfigBuild{{Name: "web", Type: "amazon-ebs", Artifact: true}}
+	uploader := &Uploader{Client: client}
+	data := "archive data"
+	result, err := uploader.UploadBuildConfig(context.Background(),
+		strings.NewReader(data), int64(len(data)), &BuildConfigOpts{
+			Slug:     "hashicorp/web",
+			Builds:   builds,
+			Metadata: map[string]interface{}{"foo": "bar"},
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !created {
+		t.Fatal("expected the build configuration to be created")
+	}
+	if string(body) != data {
+		t.Fatalf("expected %q to be %q", body, data)
+	}
+	if !reflect.DeepEqual(version.Builds, builds) {
+		t.Fatalf("expected %#v to be %#v", version.Builds, builds)
+	}
+	if version.Metadata["foo"] != "bar" || version.Metadata[SHA256Key] != result.Checksum {
+		t.Fatalf("bad metadata: %#v", version.Metadata)
+	}
+}
@@ -0,0 +1,453 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/atlas-go/v1"
+)
+
+// getApp gets the App by the given user space and name, like the atlas-go
+// client does, but returns a *statusError for unexpected responses so that
+// the request can be retried.
+func getApp(ctx context.Context, client *atlas.Client, user, name string) (*atlas.App, error) {
+	log.Printf("[INFO] getting application %s/%s", user, name)
+
+	endpoint := fmt.Sprintf("/api/v1/vagrant/applications/%s/%s", user, name)
+	request, err := client.Request("GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := checkResp(client.HTTPClient.Do(request.WithContext(ctx)))
+	if err != nil {
+		return nil, err
+	}
+
+	var app atlas.App
+	if err := decodeJSON(response, &app); err != nil {
+		return nil, err
+	}
+
+	return &app, nil
+}
+
+// appVersion represents a specific version of an App in Atlas. It is actually
+// an upload container/wrapper.
+type appVersion struct {
+	UploadPath string `json:"upload_path"`
+	Token      string `json:"token"`
+	Version    uint64 `json:"version"`
+}
+
+// createAppVersion creates a new version for the App with the given
+// metadata. The archive must then be uploaded to the upload path of the
+// returned version.
+func createAppVersion(ctx context.Context, client *atlas.Client, app *atlas.App,
+	metadata map[string]interface{}) (*appVersion, error) {
+
+	endpoint := fmt.Sprintf("/api/v1/vagrant/applications/%s/%s/versions",
+		app.User, app.Name)
+
+	// If metadata was given, setup the RequestOptions to pass in the metadata
+	// with the request.
+	var ro *atlas.RequestOptions
+	if metadata != nil {
+		// wrap the struct into the correct JSON format
+		wrapper := struct {
+			Application map[string]interface{} `json:"application"`
+		}{
+			map[string]interface{}{"metadata": metadata},
+		}
+		m, err := json.Marshal(wrapper)
+		if err != nil {
+			return nil, err
+		}
+
+		// Create the request options.
+		ro = &atlas.RequestOptions{
+			Body:       bytes.NewReader(m),
+			BodyLength: int64(len(m)),
+		}
+	}
+
+	request, err := client.Request("POST", endpoint, ro)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := checkResp(client.HTTPClient.Do(request.WithContext(ctx)))
+	if err != nil {
+		return nil, err
+	}
+
+	var av appVersion
+	if err := decodeJSON(response, &av); err != nil {
+		return nil, err
+	}
+
+	return &av, nil
+}
+
+// getArtifact gets the Artifact by the given user space and name, like the
+// atlas-go client does, but returns a *statusError for unexpected responses
+// so that the request can be retried.
+func getArtifact(ctx context.Context, client *atlas.Client, user, name string) (*atlas.Artifact, error) {
+	log.Printf("[INFO] getting artifact %s/%s", user, name)
+
+	endpoint := fmt.Sprintf("/api/v1/artifacts/%s/%s", user, name)
+	request, err := client.Request("GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := checkResp(client.HTTPClient.Do(request.WithContext(ctx)))
+	if err != nil {
+		return nil, err
+	}
+
+	var wrapper struct {
+		Artifact *atlas.Artifact `json:"artifact"`
+	}
+	if err := decodeJSON(response, &wrapper); err != nil {
+		return nil, err
+	}
+
+	return wrapper.Artifact, nil
+}
+
+// createArtifactVersion creates a new version of the artifact given by the
+// options, like the atlas-go client does with UploadArtifact, but without
+// uploading the file. If the options have a File, it must then be uploaded
+// to the upload path of the returned version.
+func createArtifactVersion(ctx context.Context, client *atlas.Client,
+	opts *atlas.UploadArtifactOpts) (*atlas.ArtifactVersion, error) {
+
+	endpoint := fmt.Sprintf("/api/v1/artifacts/%s/%s/%s",
+		opts.User, opts.Name, opts.Type)
+
+	body, err := json.Marshal(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := client.Request("POST", endpoint, &atlas.RequestOptions{
+		Body:       bytes.NewReader(body),
+		BodyLength: int64(len(body)),
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := checkResp(client.HTTPClient.Do(request.WithContext(ctx)))
+	if err != nil {
+		return nil, err
+	}
+
+	var av atlas.ArtifactVersion
+	if err := decodeJSON(response, &av); err != nil {
+		return nil, err
+	}
+
+	return &av, nil
+}
+
+// terraformConfigLatest gets the latest version of the Terraform
+// configuration, like the atlas-go client does with TerraformConfigLatest,
+// but returns a *statusError for unexpected responses so that the request
+// can be retried. It returns nil if there is no version yet.
+func terraformConfigLatest(ctx context.Context, client *atlas.Client, user, name string) (*atlas.TerraformConfigVersion, error) {
+	log.Printf("[INFO] getting terraform configuration %s/%s", user, name)
+
+	endpoint := fmt.Sprintf("/api/v1/terraform/configurations/%s/%s/versions/latest", user, name)
+	request, err := client.Request("GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := checkResp(client.HTTPClient.Do(request.WithContext(ctx)))
+	if err == atlas.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var wrapper struct {
+		Version *atlas.TerraformConfigVersion `json:"version"`
+	}
+	if err := decodeJSON(response, &wrapper); err != nil {
+		return nil, err
+	}
+
+	return wrapper.Version, nil
+}
+
+// terraformConfigVersion is a new version of a Terraform configuration that
+// the archive must be uploaded to.
+type terraformConfigVersion struct {
+	UploadPath string `json:"upload_path"`
+	Version    int    `json:"version"`
+}
+
+// createTerraformConfigVersion creates a new version of the Terraform
+// configuration, like the atlas-go client does with
+// CreateTerraformConfigVersion, but without uploading the archive. It must
+// then be uploaded to the upload path of the returned version.
+func createTerraformConfigVersion(ctx context.Context, client *atlas.Client, user, name string,
+	version *atlas.TerraformConfigVersion) (*terraformConfigVersion, error) {
+
+	endpoint := fmt.Sprintf("/api/v1/terraform/configurations/%s/%s/versions", user, name)
+
+	body, err := json.Marshal(map[string]interface{}{"version": version})
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := client.Request("POST", endpoint, &atlas.RequestOptions{
+		Body:       bytes.NewReader(body),
+		BodyLength: int64(len(body)),
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := checkResp(client.HTTPClient.Do(request.WithContext(ctx)))
+	if err != nil {
+		return nil, err
+	}
+
+	var tv terraformConfigVersion
+	if err := decodeJSON(response, &tv); err != nil {
+		return nil, err
+	}
+
+	return &tv, nil
+}
+
+// getBuildConfig gets the BuildConfig by the given user space and name, like
+// the atlas-go client does, but returns a *statusError for unexpected
+// responses so that the request can be retried.
+func getBuildConfig(ctx context.Context, client *atlas.Client, user, name string) (*atlas.BuildConfig, error) {
+	log.Printf("[INFO] getting build configuration %s/%s", user, name)
+
+	endpoint := fmt.Sprintf("/api/v1/packer/build-configurations/%s/%s", user, name)
+	request, err := client.Request("GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := checkResp(client.HTTPClient.Do(request.WithContext(ctx)))
+	if err != nil {
+		return nil, err
+	}
+
+	var bc atlas.BuildConfig
+	if err := decodeJSON(response, &bc); err != nil {
+		return nil, err
+	}
+
+	return &bc, nil
+}
+
+// createBuildConfigVersion creates a new version of the build configuration
+// with the given builds and metadata, like the atlas-go client does with
+// UploadBuildConfigVersion, but without uploading the archive. It returns
+// the upload path that the archive must then be uploaded to.
+func createBuildConfigVersion(ctx context.Context, client *atlas.Client, user, name string,
+	builds []atlas.BuildConfigBuild, metadata map[string]interface{}) (string, error) {
+
+	endpoint := fmt.Sprintf("/api/v1/packer/build-configurations/%s/%s/versions",
+		user, name)
+
+	var wrapper struct {
+		Version struct {
+			Metadata map[string]interface{}   `json:"metadata,omitempty"`
+			Builds   []atlas.BuildConfigBuild `json:"builds"`
+		} `json:"version"`
+	}
+	wrapper.Version.Metadata = metadata
+	wrapper.Version.Builds = builds
+	body, err := json.Marshal(wrapper)
+	if err != nil {
+		return "", err
+	}
+
+	request, err := client.Request("POST", endpoint, &atlas.RequestOptions{
+		Body:       bytes.NewReader(body),
+		BodyLength: int64(len(body)),
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	response, err := checkResp(client.HTTPClient.Do(request.WithContext(ctx)))
+	if err != nil {
+		return "", err
+	}
+
+	var bv struct {
+		UploadPath string `json:"upload_path"`
+	}
+	if err := decodeJSON(response, &bv); err != nil {
+		return "", err
+	}
+
+	return bv.UploadPath, nil
+}
+
+// putFile uploads the data to the given upload path. The header holds extra
+// headers to send, such as the checksums of the data, and may be nil. If the
+// size is negative, the data is sent with chunked transfer encoding, and the
+// trailer, if not nil, is sent after it; its values may be set until the
+// data has been read.
+func putFile(ctx context.Context, client *atlas.Client, uploadPath string,
+	r io.Reader, size int64, header, trailer http.Header) error {
+	log.Printf("[INFO] putting file: %s", uploadPath)
+
+	// The transport closes the body when it is done with it, but the archive
+	// must stay open in case the upload is retried.
+	request, err := http.NewRequest("PUT", uploadPath, ioutil.NopCloser(r))
+	if err != nil {
+		return err
+	}
+
+	for k, v := range client.DefaultHeader {
+		request.Header[k] = v
+	}
+	for k, v := range header {
+		request.Header[k] = v
+	}
+	request.ContentLength = size
+	httpClient := client.HTTPClient
+	if size < 0 {
+		request.TransferEncoding = []string{"chunked"}
+		request.Trailer = trailer
+
+		// Let the server reject chunked uploads before the data is sent,
+		// so that the archive isn't written in vain
+		request.Header.Set("Expect", "100-continue")
+		httpClient = expectContinueClient(httpClient)
+		defer httpClient.CloseIdleConnections()
+	}
+
+	_, err = checkResp(httpClient.Do(request.WithContext(ctx)))
+	return err
+}
+
+// expectContinueTimeout is how long a request with "Expect: 100-continue"
+// waits for the server to accept it before the body is sent anyway, for
+// servers that ignore the header.
+const expectContinueTimeout = 5 * time.Second
+
+// expectContinueClient returns a copy of the client whose transport waits
+// for the server to accept a request with "Expect: 100-continue" before the
+// body is sent. The transport of the atlas-go client, like the default one,
+// has no ExpectContinueTimeout, which sends the body right away. A client
+// with a transport that isn't an *http.Transport is returned as-is.
+func expectContinueClient(c *http.Client) *http.Client {
+	t, ok := c.Transport.(*http.Transport)
+	if c.Transport == nil {
+		t, ok = http.DefaultTransport.(*http.Transport)
+	}
+	if !ok || t.ExpectContinueTimeout > 0 {
+		return c
+	}
+
+	t = t.Clone()
+	t.ExpectContinueTimeout = expectContinueTimeout
+
+	copied := *c
+	copied.Transport = t
+	return &copied
+}
+
+// statusError is the error returned for a response with an unexpected status
+// code. The message matches the one of the atlas-go client.
+type statusError struct {
+	StatusCode int
+	Status     string
+
+	// RetryAfter is the Retry-After header of the response, if any.
+	RetryAfter string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("client: %s", e.Status)
+}
+
+// checkResp wraps http.Client.Do() and verifies that the request was
+// successful, exactly like the atlas-go client does, except that unexpected
+// responses are returned as a *statusError.
+func checkResp(resp *http.Response, err error) (*http.Response, error) {
+	// If the err is already there, there was an error higher up the chain, so
+	// just return that
+	if err != nil {
+		return resp, err
+	}
+
+	log.Printf("[INFO] response: %d (%s)", resp.StatusCode, resp.Status)
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, resp.Body); err != nil {
+		log.Printf("[ERR] response: error copying response body")
+	} else {
+		log.Printf("[DEBUG] response: %s", buf.String())
+	}
+
+	// The body has been read completely, so close it and replace it with
+	// the buffered copy.
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(&buf)
+
+	switch resp.StatusCode {
+	case 200, 201, 202, 204:
+		return resp, nil
+	case 400, 422:
+		return nil, parseErr(resp)
+	case 401:
+		return nil, atlas.ErrAuth
+	case 404:
+		return nil, atlas.ErrNotFound
+	default:
+		return nil, &statusError{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			RetryAfter: resp.Header.Get("Retry-After"),
+		}
+	}
+}
+
+// parseErr is used to take an error JSON response and return a single string
+// for use in error messages.
+func parseErr(r *http.Response) error {
+	re := &atlas.RailsError{}
+
+	if err := decodeJSON(r, &re); err != nil {
+		return fmt.Errorf("error decoding JSON body: %s", err)
+	}
+
+	return re
+}
+
+// decodeJSON is used to JSON decode a body into an interface.
+func decodeJSON(resp *http.Response, out interface{}) error {
+	defer resp.Body.Close()
+	dec := json.NewDecoder(resp.Body)
+	return dec.Decode(out)
+}
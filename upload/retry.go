@@ -0,0 +1,124 @@
+package upload
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"time"
+)
+
+// RetryOpts are the options for retrying requests to Atlas that failed with
+// a transient error. Requests that create something are only retried if the
+// server certainly didn't process them, see isUnprocessed.
+type RetryOpts struct {
+	// Attempts is the maximum number of attempts for each request. A value
+	// of one or less means that requests are never retried.
+	Attempts int
+
+	// Backoff is how long to wait before the first retry. The wait is
+	// doubled after every retry.
+	Backoff time.Duration
+
+	// MaxWait is the maximum wait between two attempts. If it is zero, the
+	// wait is not capped.
+	MaxWait time.Duration
+}
+
+// retry calls f until it succeeds, it returns an error that is not
+// transient, the maximum number of attempts is reached or the context is
+// done. The last error is returned. Every retry is logged with the given
+// description.
+func retry(ctx context.Context, opts *RetryOpts, desc string, f func() error) error {
+	return retryIf(ctx, opts, desc, isTransient, f)
+}
+
+// retryIf is like retry, but only retries the errors for which retryable
+// returns true.
+func retryIf(ctx context.Context, opts *RetryOpts, desc string,
+	retryable func(error) bool, f func() error) error {
+	wait := opts.Backoff
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || attempt >= opts.Attempts || !retryable(err) || ctx.Err() != nil {
+			return err
+		}
+
+		log.Printf("[WARN] %s failed (attempt %d of %d), retrying in %s: %s",
+			desc, attempt, opts.Attempts, wait, err)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return err
+		}
+
+		wait *= 2
+		if opts.MaxWait > 0 && wait > opts.MaxWait {
+			wait = opts.MaxWait
+		}
+	}
+}
+
+// isTransient says whether the error is likely to go away when the request
+// is retried: server errors other than 501 Not Implemented, rate limiting
+// and network errors such as connection resets and timeouts.
+func isTransient(err error) bool {
+	switch err := err.(type) {
+	case *statusError:
+		return err.StatusCode >= 500 && err.StatusCode != 501 || err.StatusCode == 429
+	case *url.Error:
+		// The http.Client returns a *url.Error for everything that went
+		// wrong on the way to the server or back, including bad URLs and
+		// certificates that no retry will fix.
+		return isTransientNetError(err.Err)
+	}
+
+	return err == io.ErrUnexpectedEOF
+}
+
+// isUnprocessed says whether the error shows that the server didn't process
+// the request, so that a request that isn't idempotent, such as creating a
+// version, can be retried without doing it twice: the connection was
+// refused, the request was rate limited, or the server was unavailable and
+// said when to try again. After a timeout or a connection that broke off,
+// the server may have processed the request anyway.
+func isUnprocessed(err error) bool {
+	switch err := err.(type) {
+	case *statusError:
+		return err.StatusCode == 429 || err.StatusCode == 503 && err.RetryAfter != ""
+	case *url.Error:
+		return isRefused(err.Err)
+	}
+
+	return false
+}
+
+// isRefused says whether the error is of a connection that was refused.
+func isRefused(err error) bool {
+	for _, refusedErr := range refusedErrors {
+		if errors.Is(err, refusedErr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isTransientNetError says whether the error of a request that got no
+// response is a timeout, a connection that was reset or refused, or a
+// connection that was closed in the middle of the response.
+func isTransientNetError(err error) bool {
+	if e, ok := err.(net.Error); ok && e.Timeout() {
+		return true
+	}
+
+	for _, connErr := range connErrors {
+		if errors.Is(err, connErr) {
+			return true
+		}
+	}
+
+	return errors.Is(err, io.ErrUnexpectedEOF)
+}
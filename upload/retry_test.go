@@ -0,0 +1,157 @@
+package upload
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRetry(t *testing.T) {
+	var calls int
+	err := retry(context.Background(), &RetryOpts{Attempts: 3, Backoff: time.Millisecond}, "test", func() error {
+		calls++
+		if calls < 3 {
+			return &statusError{StatusCode: 500, Status: "500 Internal Server Error"}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if calls != 3 {
+		t.Fatalf("expected %d calls, got %d", 3, calls)
+	}
+}
+
+func TestRetry_maxAttempts(t *testing.T) {
+	var calls int
+	expected := &statusError{StatusCode: 503, Status: "503 Service Unavailable"}
+	err := retry(context.Background(), &RetryOpts{Attempts: 2}, "test", func() error {
+		calls++
+		return expected
+	})
+	if err != expected {
+		t.Fatalf("expected %v to be %v", err, expected)
+	}
+
+	if calls != 2 {
+		t.Fatalf("expected %d calls, got %d", 2, calls)
+	}
+}
+
+func TestRetry_permanent(t *testing.T) {
+	var calls int
+	expected := errors.New("permanent")
+	err := retry(context.Background(), &RetryOpts{Attempts: 5}, "test", func() error {
+		calls++
+		return expected
+	})
+	if err != expected {
+		t.Fatalf("expected %v to be %v", err, expected)
+	}
+
+	if calls != 1 {
+		t.Fatalf("expected %d calls, got %d", 1, calls)
+	}
+}
+
+// testURLError returns the error of the http.Client for a request that
+// failed with the given error.
+func testURLError(err error) error {
+	return &url.Error{Op: "Put", URL: "https://example.com", Err: err}
+}
+
+func TestIsTransient(t *testing.T) {
+	cases := []struct {
+		Err      error
+		Expected bool
+	}{
+		{&statusError{StatusCode: 500}, true},
+		{&statusError{StatusCode: 502}, true},
+		{&statusError{StatusCode: 429}, true},
+		{&statusError{StatusCode: 409}, false},
+		{testURLError(&net.DNSError{Err: "i/o timeout", IsTimeout: true}), true},
+		{testURLError(io.ErrUnexpectedEOF), true},
+		{testURLError(errors.New(`unsupported protocol scheme "ftp"`)), false},
+		{testURLError(x509.UnknownAuthorityError{}), false},
+		{testURLError(errors.New("connection reset by peer")), false},
+		{io.ErrUnexpectedEOF, true},
+		{errors.New("malformed slug"), false},
+	}
+	for _, connErr := range connErrors {
+		cases = append(cases, struct {
+			Err      error
+			Expected bool
+		}{testURLError(&net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", connErr)}), true})
+	}
+
+	for _, tc := range cases {
+		if actual := isTransient(tc.Err); actual != tc.Expected {
+			t.Errorf("%#v: expected %t to be %t", tc.Err, actual, tc.Expected)
+		}
+	}
+}
+
+func TestIsUnprocessed(t *testing.T) {
+	cases := []struct {
+		Err      error
+		Expected bool
+	}{
+		{&statusError{StatusCode: 429}, true},
+		{&statusError{StatusCode: 503, RetryAfter: "5"}, true},
+		{&statusError{StatusCode: 503}, false},
+		{&statusError{StatusCode: 500}, false},
+		{&statusError{StatusCode: 502, RetryAfter: "5"}, false},
+		{testURLError(&net.DNSError{Err: "i/o timeout", IsTimeout: true}), false},
+		{testURLError(io.ErrUnexpectedEOF), false},
+		{io.ErrUnexpectedEOF, false},
+		{errors.New("malformed slug"), false},
+	}
+	for _, refusedErr := range refusedErrors {
+		cases = append(cases, struct {
+			Err      error
+			Expected bool
+		}{testURLError(&net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", refusedErr)}), true})
+	}
+	for _, connErr := range connErrors {
+		if isRefused(connErr) {
+			continue
+		}
+		cases = append(cases, struct {
+			Err      error
+			Expected bool
+		}{testURLError(&net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", connErr)}), false})
+	}
+
+	for _, tc := range cases {
+		if actual := isUnprocessed(tc.Err); actual != tc.Expected {
+			t.Errorf("%#v: expected %t to be %t", tc.Err, actual, tc.Expected)
+		}
+	}
+}
+
+func TestRetry_canceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var calls int
+	expected := &statusError{StatusCode: 503, Status: "503 Service Unavailable"}
+	err := retry(ctx, &RetryOpts{Attempts: 5, Backoff: time.Hour}, "test", func() error {
+		calls++
+		cancel()
+		return expected
+	})
+	if err != expected {
+		t.Fatalf("expected %v to be %v", err, expected)
+	}
+
+	if calls != 1 {
+		t.Fatalf("expected %d calls, got %d", 1, calls)
+	}
+}
@@ -0,0 +1,246 @@
+package upload
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/atlas-go/v1"
+)
+
+// WriteFunc writes a complete archive to w. It is called again for every
+// attempt to send the archive, and must write the same bytes every time,
+// like archive.Stream does.
+type WriteFunc func(w io.Writer) error
+
+// UploadStream is like Upload, but the archive is written by the given
+// function while it is sent instead of being read from a file, so that it
+// never has to be stored anywhere. It is sent with chunked transfer
+// encoding. If the server doesn't accept that, the archive is written once
+// to learn its size and checksum, and then written again while it is sent.
+//
+// Since the checksums of the archive are only known once it was sent, they
+// are not sent as metadata, and opts.SHA256 and opts.MD5 can't be used.
+// With opts.DigestHeaders, the Digest header is sent as a trailer after the
+// archive.
+func (u *Uploader) UploadStream(ctx context.Context, write WriteFunc, opts *Opts) (*Result, error) {
+	start := time.Now()
+
+	if opts.SHA256 != "" || opts.MD5 != "" {
+		return nil, fmt.Errorf("upload: checksums are not supported when streaming")
+	}
+
+	user, name, err := atlas.ParseSlug(opts.Slug)
+	if err != nil {
+		return nil, fmt.Errorf("upload: %s", err)
+	}
+
+	app, created, err := u.app(ctx, user, name, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := make(map[string]interface{}, len(opts.Metadata)+1)
+	for k, v := range opts.Metadata {
+		metadata[k] = v
+	}
+	if opts.ContentHash != "" {
+		metadata[ContentHashKey] = opts.ContentHash
+	}
+
+	log.Printf("[INFO] streaming application %s with metadata %q", app.Slug(), metadata)
+
+	var av *appVersion
+	err = retryIf(ctx, &u.Retry, "creating application version", isUnprocessed, func() error {
+		var err error
+		av, err = createAppVersion(ctx, u.Client, app, metadata)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sent, err := u.putStream(ctx, av.UploadPath, write, opts.DigestHeaders)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Result{
+		Version:  av.Version,
+		Size:     sent.size,
+		Checksum: sent.sha256,
+		Duration: time.Since(start),
+		Metadata: metadata,
+		Created:  created,
+	}, nil
+}
+
+// sentArchive describes an archive that was sent.
+type sentArchive struct {
+	size   int64
+	sha256 string
+}
+
+// putStream sends the archive that write writes to the given upload path
+// with chunked transfer encoding. If the server rejects that, it falls back
+// to sending it with its size, which is learned by writing it once.
+func (u *Uploader) putStream(ctx context.Context, uploadPath string,
+	write WriteFunc, digest bool) (*sentArchive, error) {
+	var sent *sentArchive
+	err := retry(ctx, &u.Retry, "uploading archive", func() error {
+		var err error
+		sent, err = u.putWritten(ctx, uploadPath, write, -1, nil, digest)
+		return err
+	})
+	if !rejectsChunked(err) {
+		return sent, err
+	}
+
+	log.Printf("[INFO] the server doesn't accept chunked uploads (%s), "+
+		"writing the archive to learn its size", err)
+	expected, err := written(write)
+	if err != nil {
+		return nil, err
+	}
+
+	var header http.Header
+	if digest {
+		header, err = digestHeader(expected.sha256, "")
+		if err != nil {
+			return nil, fmt.Errorf("upload: %s", err)
+		}
+	}
+
+	err = retry(ctx, &u.Retry, "uploading archive", func() error {
+		var err error
+		sent, err = u.putWritten(ctx, uploadPath, write, expected.size, header, false)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if sent.sha256 != expected.sha256 {
+		return nil, checksumMismatch(sent.sha256, expected.sha256)
+	}
+
+	return sent, nil
+}
+
+// rejectsChunked says whether the error means that the server doesn't
+// accept uploads with chunked transfer encoding.
+func rejectsChunked(err error) bool {
+	e, ok := err.(*statusError)
+	return ok && (e.StatusCode == http.StatusLengthRequired ||
+		e.StatusCode == http.StatusNotImplemented)
+}
+
+// written writes the archive without sending it, and returns its size and
+// checksums.
+func written(write WriteFunc) (*sentArchive, error) {
+	h := newArchiveHash()
+	if err := write(h.writer(ioutil.Discard)); err != nil {
+		return nil, err
+	}
+
+	return h.sum(), nil
+}
+
+// putWritten sends the archive that write writes to the given upload path
+// while it is written. A negative size sends it with chunked transfer
+// encoding, and then digest sends its checksums in a Digest trailer.
+func (u *Uploader) putWritten(ctx context.Context, uploadPath string,
+	write WriteFunc, size int64, header http.Header, digest bool) (*sentArchive, error) {
+	var trailer http.Header
+	if digest {
+		trailer = http.Header{"Digest": nil}
+	}
+
+	pr, pw := io.Pipe()
+	sink := &pipeWriter{w: pw}
+	h := newArchiveHash()
+	done := make(chan error, 1)
+	go func() {
+		err := write(h.writer(sink))
+		if err == nil && trailer != nil {
+			d, err := digestHeader(h.sum().sha256, "")
+			if err == nil {
+				trailer.Set("Digest", d.Get("Digest"))
+			}
+		}
+
+		// The trailer must be set before the request sees the end of the
+		// archive
+		pw.CloseWithError(err)
+		done <- err
+	}()
+
+	var body io.Reader = pr
+	if u.ProgressFunc != nil {
+		body = &progressReader{r: body, total: size, f: u.ProgressFunc}
+	}
+
+	err := putFile(ctx, u.Client, uploadPath, body, size, header, trailer)
+
+	// Stop writing if the request failed before the end of the archive
+	pr.CloseWithError(io.ErrClosedPipe)
+
+	// An error of the write function itself, rather than of the request
+	// that stopped reading, is the reason the upload failed
+	if werr := <-done; werr != nil && (sink.err == nil || err == nil) {
+		return nil, werr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return h.sum(), nil
+}
+
+// pipeWriter is the writer of a pipe that remembers whether writing to it
+// failed, to tell errors of the reader apart from errors of the writer.
+type pipeWriter struct {
+	w   *io.PipeWriter
+	err error
+}
+
+func (p *pipeWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	if err != nil {
+		p.err = err
+	}
+	return n, err
+}
+
+// archiveHash counts and checksums an archive while it is written.
+type archiveHash struct {
+	size   int64
+	sha256 hash.Hash
+}
+
+func newArchiveHash() *archiveHash {
+	return &archiveHash{sha256: sha256.New()}
+}
+
+// writer returns a writer that writes to w and to the hash.
+func (h *archiveHash) writer(w io.Writer) io.Writer {
+	return io.MultiWriter(w, h.sha256, h)
+}
+
+func (h *archiveHash) Write(p []byte) (int, error) {
+	h.size += int64(len(p))
+	return len(p), nil
+}
+
+func (h *archiveHash) sum() *sentArchive {
+	return &sentArchive{
+		size:   h.size,
+		sha256: hex.EncodeToString(h.sha256.Sum(nil)),
+	}
+}
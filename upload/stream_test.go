@@ -0,0 +1,143 @@
+package upload
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUploader_UploadStream(t *testing.T) {
+	var body []byte
+	var contentLength int64
+	var trailer http.Header
+	server, client := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+		contentLength = r.ContentLength
+		trailer = r.Trailer
+	})
+	defer server.Close()
+
+	var progress, progressTotal int64
+	uploader := &Uploader{
+		Client: client,
+		ProgressFunc: func(current, total int64) {
+			progress, progressTotal = current, total
+		},
+	}
+
+	data := "archive data"
+	result, err := uploader.UploadStream(context.Background(), func(w io.Writer) error {
+		_, err := io.WriteString(w, data)
+		return err
+	}, &Opts{Slug: "hashicorp/project", DigestHeaders: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(body) != data {
+		t.Fatalf("expected %q to be %q", body, data)
+	}
+	if contentLength != -1 {
+		t.Fatalf("expected a chunked upload, got a length of %d", contentLength)
+	}
+
+	sum := sha256.Sum256([]byte(data))
+	if expected := hex.EncodeToString(sum[:]); result.Checksum != expected {
+		t.Fatalf("expected %q to be %q", result.Checksum, expected)
+	}
+	if result.Size != int64(len(data)) {
+		t.Fatalf("expected %d to be %d", result.Size, len(data))
+	}
+	if _, ok := result.Metadata[SHA256Key]; ok {
+		t.Fatalf("expected no checksum in the metadata: %#v", result.Metadata)
+	}
+
+	expected := "SHA-256=" + base64.StdEncoding.EncodeToString(sum[:])
+	if trailer.Get("Digest") != expected {
+		t.Fatalf("expected %q to be %q", trailer.Get("Digest"), expected)
+	}
+
+	if progress != int64(len(data)) || progressTotal != int64(len(data)) {
+		t.Fatalf("expected progress %d/%d to be %d", progress, progressTotal, len(data))
+	}
+}
+
+func TestUploader_UploadStream_lengthRequired(t *testing.T) {
+	var body []byte
+	var contentLength int64
+	var attempts int
+	server, client := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if r.ContentLength < 0 {
+			w.WriteHeader(http.StatusLengthRequired)
+			return
+		}
+
+		body, _ = ioutil.ReadAll(r.Body)
+		contentLength = r.ContentLength
+	})
+	defer server.Close()
+
+	var written []int
+	data := "archive data"
+	uploader := &Uploader{Client: client}
+	_, err := uploader.UploadStream(context.Background(), func(w io.Writer) error {
+		n, err := io.WriteString(w, data)
+		written = append(written, n)
+		return err
+	}, &Opts{Slug: "hashicorp/project"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(body) != data {
+		t.Fatalf("expected %q to be %q", body, data)
+	}
+	if contentLength != int64(len(data)) {
+		t.Fatalf("expected %d to be %d", contentLength, len(data))
+	}
+	if attempts != 2 {
+		t.Fatalf("expected %d attempts, got %d", 2, attempts)
+	}
+
+	// Once for the chunked upload, once to learn the size and once to send
+	// it. The server rejects the chunked upload before any data is sent.
+	if expected := []int{0, len(data), len(data)}; !reflect.DeepEqual(written, expected) {
+		t.Fatalf("expected %#v to be %#v", written, expected)
+	}
+}
+
+func TestUploader_UploadStream_writeError(t *testing.T) {
+	server, client := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+	})
+	defer server.Close()
+
+	writeErr := errors.New("file changed")
+	uploader := &Uploader{Client: client}
+	_, err := uploader.UploadStream(context.Background(), func(w io.Writer) error {
+		io.WriteString(w, "archive")
+		return writeErr
+	}, &Opts{Slug: "hashicorp/project"})
+	if err != writeErr {
+		t.Fatalf("expected %v to be %v", err, writeErr)
+	}
+}
+
+func TestUploader_UploadStream_checksums(t *testing.T) {
+	uploader := &Uploader{}
+	_, err := uploader.UploadStream(context.Background(), func(w io.Writer) error {
+		return nil
+	}, &Opts{Slug: "hashicorp/project", SHA256: "abc"})
+	if err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Fatalf("expected an error, got %v", err)
+	}
+}
@@ -0,0 +1,147 @@
+package upload
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/hashicorp/atlas-go/v1"
+)
+
+// TerraformOpts are the options for uploading a single Terraform
+// configuration version.
+type TerraformOpts struct {
+	// Slug is the "user/name" of the Terraform configuration to upload.
+	Slug string
+
+	// Variables are the Terraform variables to set on the new version.
+	Variables []atlas.TFVar
+
+	// ReplaceVariables, if true, only sets the given Variables. Otherwise
+	// the variables of the latest version that are not given are kept, so
+	// that variables which were set in Atlas are not lost.
+	ReplaceVariables bool
+
+	// Metadata is the arbitrary metadata to upload with this version, and
+	// Remotes are the URLs of the VCS remotes the configuration came from.
+	Metadata map[string]string
+	Remotes  []string
+
+	// SHA256 and DigestHeaders are like Opts.SHA256 and Opts.DigestHeaders.
+	SHA256        string
+	DigestHeaders bool
+}
+
+// TerraformResult is the result of a successful Terraform configuration
+// upload.
+type TerraformResult struct {
+	// Version is the version number of the configuration that was created.
+	Version int
+
+	// Size is the size of the archive in bytes, and Checksum is its
+	// hex-encoded SHA-256 checksum.
+	Size     int64
+	Checksum string
+
+	// Duration is like Result.Duration.
+	Duration time.Duration
+
+	// Metadata and Variables are what was sent with the upload, including
+	// the variables that were kept from the latest version.
+	Metadata  map[string]string
+	Variables []atlas.TFVar
+}
+
+// UploadTerraform creates a new version of the Terraform configuration given
+// by the options and uploads the archive of the given size to it. The
+// configuration is created by Atlas if it does not exist yet.
+//
+// Requests are retried and canceled like they are for Upload.
+func (u *Uploader) UploadTerraform(ctx context.Context, r io.ReadSeeker, size int64, opts *TerraformOpts) (*TerraformResult, error) {
+	start := time.Now()
+
+	user, name, err := atlas.ParseSlug(opts.Slug)
+	if err != nil {
+		return nil, fmt.Errorf("upload: %s", err)
+	}
+
+	variables := opts.Variables
+	if !opts.ReplaceVariables {
+		var latest *atlas.TerraformConfigVersion
+		err = retry(ctx, &u.Retry, "getting latest configuration", func() error {
+			var err error
+			latest, err = terraformConfigLatest(ctx, u.Client, user, name)
+			return err
+		})
+		if err != nil {
+			return nil, &Error{Err: err}
+		}
+
+		if latest != nil {
+			variables = mergeVariables(latest.TFVars, variables)
+		}
+	}
+
+	sum, header, err := archiveChecksum(r, opts.SHA256, "", opts.DigestHeaders)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := make(map[string]string, len(opts.Metadata)+1)
+	for k, v := range opts.Metadata {
+		metadata[k] = v
+	}
+	metadata[SHA256Key] = sum
+
+	log.Printf("[INFO] uploading terraform configuration %s/%s (%d bytes) with metadata %q",
+		user, name, size, metadata)
+
+	version := &atlas.TerraformConfigVersion{
+		Remotes:  opts.Remotes,
+		Metadata: metadata,
+		TFVars:   variables,
+	}
+
+	var tv *terraformConfigVersion
+	err = u.sendArchive(ctx, "creating configuration version", func() (string, error) {
+		var err error
+		tv, err = createTerraformConfigVersion(ctx, u.Client, user, name, version)
+		if err != nil {
+			return "", err
+		}
+		return tv.UploadPath, nil
+	}, r, size, sum, header)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TerraformResult{
+		Version:   tv.Version,
+		Size:      size,
+		Checksum:  sum,
+		Duration:  time.Since(start),
+		Metadata:  metadata,
+		Variables: variables,
+	}, nil
+}
+
+// mergeVariables returns the latest variables that are not overridden,
+// followed by the given variables.
+func mergeVariables(latest, given []atlas.TFVar) []atlas.TFVar {
+	set := make(map[string]bool, len(given))
+	for _, v := range given {
+		set[v.Key] = true
+	}
+
+	var result []atlas.TFVar
+	for _, v := range latest {
+		if !set[v.Key] {
+			log.Printf("[DEBUG] keeping variable %q of the latest version", v.Key)
+			result = append(result, v)
+		}
+	}
+
+	return append(result, given...)
+}
@@ -0,0 +1,149 @@
+package upload
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/atlas-go/v1"
+)
+
+// testTerraformServer returns a server that implements the Atlas endpoints
+// that are needed to upload the "hashicorp/infra" configuration. The latest
+// version has the given variables, or there is none if they are nil. The
+// version that is created is decoded into version, and the archive is read
+// into body.
+func testTerraformServer(t *testing.T, latest []atlas.TFVar, version **atlas.TerraformConfigVersion, body *[]byte) (*httptest.Server, *atlas.Client) {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+
+	mux.HandleFunc("/api/v1/terraform/configurations/hashicorp/infra/versions/latest", func(w http.ResponseWriter, r *http.Request) {
+		if latest == nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"version": &atlas.TerraformConfigVersion{Version: 1, TFVars: latest},
+		})
+	})
+	mux.HandleFunc("/api/v1/terraform/configurations/hashicorp/infra/versions", func(w http.ResponseWriter, r *http.Request) {
+		var wrapper struct {
+			Version *atlas.TerraformConfigVersion `json:"version"`
+		}
+		json.NewDecoder(r.Body).Decode(&wrapper)
+		*version = wrapper.Version
+
+		fmt.Fprintf(w, `{"upload_path": "%s/upload", "version": 2}`, server.URL)
+	})
+	mux.HandleFunc("/upload", func(w http.ResponseWriter, r *http.Request) {
+		*body, _ = ioutil.ReadAll(r.Body)
+	})
+
+	client, err := atlas.NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return server, client
+}
+
+func TestUploader_UploadTerraform(t *testing.T) {
+	var version *atlas.TerraformConfigVersion
+	var body []byte
+	latest := []atlas.TFVar{
+		{Key: "region", Value: "us-west-2"},
+		{Key: "secret", Value: "set in Atlas"},
+	}
+	server, client := testTerraformServer(t, latest, &version, &body)
+	defer server.Close()
+
+	uploader := &Uploader{Client: client}
+	data := "archive data"
+	result, err := uploader.UploadTerraform(context.Background(),
+		strings.NewReader(data), int64(len(data)), &TerraformOpts{
+			Slug:      "hashicorp/infra",
+			Variables: []atlas.TFVar{{Key: "region", Value: "us-east-1"}},
+			Metadata:  map[string]string{"commit": "abc"},
+			Remotes:   []string{"git@github.com:hashicorp/infra.git"},
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Version != 2 {
+		t.Fatalf("expected %d to be %d", result.Version, 2)
+	}
+	if string(body) != data {
+		t.Fatalf("expected %q to be %q", body, data)
+	}
+
+	// The variables that were not given are kept
+	expected := []atlas.TFVar{
+		{Key: "secret", Value: "set in Atlas"},
+		{Key: "region", Value: "us-east-1"},
+	}
+	if !reflect.DeepEqual(version.TFVars, expected) {
+		t.Fatalf("expected %#v to be %#v", version.TFVars, expected)
+	}
+	if version.Metadata["commit"] != "abc" || version.Metadata[SHA256Key] != result.Checksum {
+		t.Fatalf("bad metadata: %#v", version.Metadata)
+	}
+	if !reflect.DeepEqual(version.Remotes, []string{"git@github.com:hashicorp/infra.git"}) {
+		t.Fatalf("bad remotes: %#v", version.Remotes)
+	}
+}
+
+func TestUploader_UploadTerraform_replaceVariables(t *testing.T) {
+	var version *atlas.TerraformConfigVersion
+	var body []byte
+	latest := []atlas.TFVar{{Key: "secret", Value: "set in Atlas"}}
+	server, client := testTerraformServer(t, latest, &version, &body)
+	defer server.Close()
+
+	uploader := &Uploader{Client: client}
+	data := "archive data"
+	vars := []atlas.TFVar{{Key: "zones", Value: `["a"]`, IsHCL: true}}
+	_, err := uploader.UploadTerraform(context.Background(),
+		strings.NewReader(data), int64(len(data)), &TerraformOpts{
+			Slug:             "hashicorp/infra",
+			Variables:        vars,
+			ReplaceVariables: true,
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(version.TFVars, vars) {
+		t.Fatalf("expected %#v to be %#v", version.TFVars, vars)
+	}
+}
+
+func TestUploader_UploadTerraform_noLatest(t *testing.T) {
+	var version *atlas.TerraformConfigVersion
+	var body []byte
+	server, client := testTerraformServer(t, nil, &version, &body)
+	defer server.Close()
+
+	uploader := &Uploader{Client: client}
+	data := "archive data"
+	vars := []atlas.TFVar{{Key: "region", Value: "us-east-1"}}
+	_, err := uploader.UploadTerraform(context.Background(),
+		strings.NewReader(data), int64(len(data)), &TerraformOpts{
+			Slug:      "hashicorp/infra",
+			Variables: vars,
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(version.TFVars, vars) {
+		t.Fatalf("expected %#v to be %#v", version.TFVars, vars)
+	}
+}
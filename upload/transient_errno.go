@@ -0,0 +1,14 @@
+//go:build !plan9 && !windows
+// +build !plan9,!windows
+
+package upload
+
+import "syscall"
+
+// refusedErrors are the errors of connections that were refused, which means
+// that the request wasn't sent.
+var refusedErrors = []error{syscall.ECONNREFUSED}
+
+// connErrors are the errors of connections that were reset or refused, which
+// are worth retrying.
+var connErrors = append([]error{syscall.ECONNRESET}, refusedErrors...)
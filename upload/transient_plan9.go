@@ -0,0 +1,11 @@
+package upload
+
+// refusedErrors are the errors of connections that were refused, which means
+// that the request wasn't sent. Plan 9 reports them as strings, so creating
+// a version is only retried after rate limiting there.
+var refusedErrors []error
+
+// connErrors are the errors of connections that were reset or refused, which
+// are worth retrying. Plan 9 reports them as strings, so only timeouts and
+// connections that closed early are retried there.
+var connErrors []error
@@ -0,0 +1,15 @@
+package upload
+
+import "syscall"
+
+// wsaeconnrefused is WSAECONNREFUSED, which the syscall package doesn't
+// define.
+const wsaeconnrefused = syscall.Errno(10061)
+
+// refusedErrors are the errors of connections that were refused, which means
+// that the request wasn't sent.
+var refusedErrors = []error{wsaeconnrefused, syscall.ECONNREFUSED}
+
+// connErrors are the errors of connections that were reset or refused, which
+// are worth retrying.
+var connErrors = append([]error{syscall.WSAECONNRESET, syscall.ECONNRESET}, refusedErrors...)
@@ -0,0 +1,434 @@
+// Package upload uploads archives to Atlas. It is what the atlas-upload CLI
+// uses under the hood, and it can be imported by other Go programs that want
+// to upload to Atlas without shelling out to the CLI.
+package upload
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/atlas-go/v1"
+)
+
+// Uploader uploads archives to Atlas. The zero value is not usable; at least
+// Client must be set. An Uploader may be used for any number of uploads, but
+// not concurrently.
+type Uploader struct {
+	// Client is the Atlas client to upload with.
+	Client *atlas.Client
+
+	// Retry are the options for retrying requests that failed with a
+	// transient error. The zero value never retries.
+	Retry RetryOpts
+
+	// ProgressFunc, if set, is called while the archive is uploaded with the
+	// number of bytes that were sent so far and the total size. It starts
+	// over at zero when the upload is retried. While an archive of unknown
+	// size is streamed, the total is -1 until the end of the archive.
+	ProgressFunc func(current, total int64)
+}
+
+// Opts are the options for a single upload.
+type Opts struct {
+	// Slug is the "user/name" of the application to upload.
+	Slug string
+
+	// Metadata is the arbitrary metadata to upload with this application.
+	Metadata map[string]interface{}
+
+	// ContentHash, if set, is the hash of the content of the archive, such
+	// as archive.Archive.ContentHash. It is sent as metadata under
+	// ContentHashKey.
+	ContentHash string
+
+	// SHA256 is the hex-encoded SHA-256 checksum of the archive, such as
+	// archive.Archive.SHA256. If it is empty, it is computed by reading the
+	// archive before it is uploaded. It is sent as metadata under SHA256Key,
+	// and the upload fails if the archive that was sent doesn't match it.
+	SHA256 string
+
+	// MD5, if set, is the hex-encoded MD5 checksum of the archive. It is
+	// sent as metadata under MD5Key.
+	MD5 string
+
+	// DigestHeaders, if true, sends the checksums with the archive itself,
+	// in a Digest header (RFC 3230) and, if MD5 is set, a Content-MD5
+	// header, so that the server can check what it received.
+	DigestHeaders bool
+
+	// ConfirmCreate, if set, is called with the slug when the application
+	// doesn't exist, and it is only created if it returns true. Otherwise
+	// the upload fails with an *Error for atlas.ErrNotFound, so that a typo
+	// in the slug doesn't create a stray application. If it is nil, the
+	// application is always created.
+	ConfirmCreate func(slug string) (bool, error)
+}
+
+// Metadata keys that are set by Upload.
+const (
+	// ContentHashKey is the metadata key that Opts.ContentHash is sent as.
+	ContentHashKey = "archive.content_sha256"
+
+	// SHA256Key and MD5Key are the metadata keys that the checksums of the
+	// archive are sent as.
+	SHA256Key = "archive.sha256"
+	MD5Key    = "archive.md5"
+)
+
+// Result is the result of a successful upload.
+type Result struct {
+	// Version is the version of the application that was created.
+	Version uint64
+
+	// Size is the size of the archive in bytes.
+	Size int64
+
+	// Checksum is the hex-encoded SHA-256 checksum of the archive that was
+	// sent.
+	Checksum string
+
+	// Duration is how long the upload took, including retries.
+	Duration time.Duration
+
+	// MD5 is the hex-encoded MD5 checksum of the archive, if it was given
+	// with Opts.MD5.
+	MD5 string
+
+	// Metadata is the metadata that was sent with the upload.
+	Metadata map[string]interface{}
+
+	// Created is true if the application didn't exist and was created.
+	Created bool
+}
+
+// Error is the error that is returned when looking up or creating what is
+// uploaded to failed. Err is the error of the request, such as
+// atlas.ErrAuth, atlas.ErrNotFound, an *atlas.RailsError or a *url.Error,
+// so that callers can tell them apart. The errors of the requests that
+// create the version and send the archive are returned as they are.
+type Error struct {
+	Err error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("upload: %s", e.Err)
+}
+
+// Upload uploads the reader, representing a single archive of the given
+// size, to the application given by the options. If the application does
+// not exist, it is created unless opts.ConfirmCreate says otherwise.
+//
+// Requests that fail with a transient error are retried as configured by
+// u.Retry. The reader is rewound to the start before the archive is sent
+// again.
+//
+// Upload returns when the upload is done, it failed or the context is done.
+// In the latter case, the request that is in flight is canceled.
+func (u *Uploader) Upload(ctx context.Context, r io.ReadSeeker, size int64, opts *Opts) (*Result, error) {
+	start := time.Now()
+
+	// Separate the slug into the user and name components
+	user, name, err := atlas.ParseSlug(opts.Slug)
+	if err != nil {
+		return nil, fmt.Errorf("upload: %s", err)
+	}
+
+	// Get the app
+	app, created, err := u.app(ctx, user, name, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	sum, header, err := archiveChecksum(r, opts.SHA256, opts.MD5, opts.DigestHeaders)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := make(map[string]interface{}, len(opts.Metadata)+3)
+	for k, v := range opts.Metadata {
+		metadata[k] = v
+	}
+	if opts.ContentHash != "" {
+		metadata[ContentHashKey] = opts.ContentHash
+	}
+	metadata[SHA256Key] = sum
+	if opts.MD5 != "" {
+		metadata[MD5Key] = opts.MD5
+	}
+
+	log.Printf("[INFO] uploading application %s (%d bytes) with metadata %q",
+		app.Slug(), size, metadata)
+
+	var av *appVersion
+	err = u.sendArchive(ctx, "creating application version", func() (string, error) {
+		var err error
+		av, err = createAppVersion(ctx, u.Client, app, metadata)
+		if err != nil {
+			return "", err
+		}
+		return av.UploadPath, nil
+	}, r, size, sum, header)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Result{
+		Version:  av.Version,
+		Size:     size,
+		Checksum: sum,
+		MD5:      opts.MD5,
+		Duration: time.Since(start),
+		Metadata: metadata,
+		Created:  created,
+	}, nil
+}
+
+// App gets the application with the given slug, retrying like Upload does.
+// If it doesn't exist, it fails with an *Error for atlas.ErrNotFound. This
+// can be used to check the slug before an archive is created for Upload
+// when the application won't be created.
+func (u *Uploader) App(ctx context.Context, slug string) (*atlas.App, error) {
+	user, name, err := atlas.ParseSlug(slug)
+	if err != nil {
+		return nil, fmt.Errorf("upload: %s", err)
+	}
+
+	var app *atlas.App
+	err = retry(ctx, &u.Retry, "getting application", func() error {
+		var err error
+		app, err = getApp(ctx, u.Client, user, name)
+		return err
+	})
+	if err != nil {
+		return nil, &Error{Err: err}
+	}
+
+	return app, nil
+}
+
+// app gets the application, creating it if it doesn't exist and
+// opts.ConfirmCreate allows it. created says whether it was created.
+func (u *Uploader) app(ctx context.Context, user, name string, opts *Opts) (app *atlas.App, created bool, err error) {
+	err = u.getOrCreate(ctx, "getting application", func() error {
+		var err error
+		app, err = getApp(ctx, u.Client, user, name)
+		return err
+	}, func() error {
+		var err error
+		app, err = u.createApp(user, name, opts)
+		created = app != nil
+		return err
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	return app, created, nil
+}
+
+// getOrCreate gets what is uploaded to with get, which is retried and
+// desc describes in the log, and creates it with create if get fails with
+// atlas.ErrNotFound. Errors are returned as an *Error.
+func (u *Uploader) getOrCreate(ctx context.Context, desc string, get, create func() error) error {
+	err := retry(ctx, &u.Retry, desc, get)
+	if err == atlas.ErrNotFound {
+		err = create()
+	}
+	if err != nil {
+		return &Error{Err: err}
+	}
+
+	return nil
+}
+
+// createApp creates the application that doesn't exist, if opts.ConfirmCreate
+// allows it. If it doesn't, atlas.ErrNotFound is returned.
+func (u *Uploader) createApp(user, name string, opts *Opts) (*atlas.App, error) {
+	if opts.ConfirmCreate != nil {
+		ok, err := opts.ConfirmCreate(opts.Slug)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			log.Printf("[DEBUG] application %s/%s doesn't exist, not creating it", user, name)
+			return nil, atlas.ErrNotFound
+		}
+	}
+
+	app, err := u.Client.CreateApp(user, name)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("[WARN] application %s/%s didn't exist and was created", user, name)
+	return app, nil
+}
+
+// putArchive uploads the archive to the given upload path, rewinding it for
+// every retry, and returns the hex-encoded SHA-256 checksum of what was sent.
+func (u *Uploader) putArchive(ctx context.Context, uploadPath string,
+	r io.ReadSeeker, size int64, header http.Header) (string, error) {
+	var h hash.Hash
+	attempt := 0
+	err := retry(ctx, &u.Retry, "uploading archive", func() error {
+		// Rewind the archive if this is a retry
+		attempt++
+		if attempt > 1 {
+			if _, err := r.Seek(0, io.SeekStart); err != nil {
+				return fmt.Errorf("error rewinding archive: %s", err)
+			}
+		}
+
+		h = sha256.New()
+		var body io.Reader = io.TeeReader(r, h)
+		if u.ProgressFunc != nil {
+			body = &progressReader{r: body, total: size, f: u.ProgressFunc}
+		}
+
+		return putFile(ctx, u.Client, uploadPath, body, size, header, nil)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// archiveChecksum returns the hex-encoded SHA-256 checksum of the archive,
+// which is computed unless it is given, and the headers that send it and the
+// given MD5 checksum along with the archive if digest is true. It must be
+// known before the version is created, since it is sent as its metadata.
+func archiveChecksum(r io.ReadSeeker, sum, md5Sum string, digest bool) (string, http.Header, error) {
+	if sum == "" {
+		var err error
+		sum, err = checksum(r)
+		if err != nil {
+			return "", nil, fmt.Errorf("upload: error computing checksum: %s", err)
+		}
+	}
+
+	if !digest {
+		return sum, nil, nil
+	}
+
+	header, err := digestHeader(sum, md5Sum)
+	if err != nil {
+		return "", nil, fmt.Errorf("upload: %s", err)
+	}
+
+	return sum, header, nil
+}
+
+// sendArchive creates a new version with create, which returns the upload
+// path of the version, and sends the archive with the given checksum and
+// headers to it. Creating the version is only retried if it certainly
+// failed, see isUnprocessed, and desc describes it in the log. If the reader is nil, there is nothing to
+// send and only the version is created.
+func (u *Uploader) sendArchive(ctx context.Context, desc string, create func() (string, error),
+	r io.ReadSeeker, size int64, sum string, header http.Header) error {
+	var uploadPath string
+	err := retryIf(ctx, &u.Retry, desc, isUnprocessed, func() error {
+		var err error
+		uploadPath, err = create()
+		return err
+	})
+	if err != nil || r == nil {
+		return err
+	}
+
+	sent, err := u.putArchive(ctx, uploadPath, r, size, header)
+	if err != nil {
+		return err
+	}
+	if sent != sum {
+		return checksumMismatch(sent, sum)
+	}
+
+	return nil
+}
+
+// checksumMismatch returns the error for an upload whose data didn't have
+// the expected checksum when it was sent.
+func checksumMismatch(sent, expected string) error {
+	return fmt.Errorf(
+		"upload: checksum of the uploaded data %s doesn't match %s, "+
+			"it may have changed during the upload", sent, expected)
+}
+
+// checksum returns the hex-encoded SHA-256 checksum of the archive, and
+// rewinds it to the start.
+func checksum(r io.ReadSeeker) (string, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// digestHeader returns the headers that carry the given hex-encoded
+// checksums: a Digest header as defined by RFC 3230 and, if there is an MD5
+// checksum, a Content-MD5 header as defined by RFC 1864.
+func digestHeader(sha256Sum, md5Sum string) (http.Header, error) {
+	sha, err := hex.DecodeString(sha256Sum)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SHA-256 checksum %q", sha256Sum)
+	}
+
+	header := make(http.Header)
+	digest := "SHA-256=" + base64.StdEncoding.EncodeToString(sha)
+	if md5Sum != "" {
+		md, err := hex.DecodeString(md5Sum)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MD5 checksum %q", md5Sum)
+		}
+
+		encoded := base64.StdEncoding.EncodeToString(md)
+		digest += ",MD5=" + encoded
+		header.Set("Content-MD5", encoded)
+	}
+	header.Set("Digest", digest)
+
+	return header, nil
+}
+
+// progressReader is an io.Reader that reports the progress of reading to
+// the given function.
+type progressReader struct {
+	r       io.Reader
+	current int64
+	total   int64
+	f       func(current, total int64)
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.current += int64(n)
+		r.f(r.current, r.total)
+	}
+
+	// If the total was not known, it is now
+	if err == io.EOF && r.total < 0 {
+		r.total = r.current
+		r.f(r.current, r.total)
+	}
+
+	return n, err
+}
@@ -0,0 +1,412 @@
+package upload
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/atlas-go/v1"
+)
+
+// testServer returns a server that implements the Atlas endpoints that are
+// needed to upload the "hashicorp/project" application. The upload handler
+// receives the archive.
+func testServer(t *testing.T, upload http.HandlerFunc) (*httptest.Server, *atlas.Client) {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+
+	mux.HandleFunc("/api/v1/vagrant/applications/hashicorp/project", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"username": "hashicorp", "name": "project"}`)
+	})
+	mux.HandleFunc("/api/v1/vagrant/applications/hashicorp/project/versions", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"upload_path": "%s/upload", "version": 5}`, server.URL)
+	})
+	mux.HandleFunc("/upload", upload)
+
+	client, err := atlas.NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return server, client
+}
+
+func TestUploader_Upload(t *testing.T) {
+	var body []byte
+	server, client := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+	})
+	defer server.Close()
+
+	var progress int64
+	uploader := &Uploader{
+		Client: client,
+		ProgressFunc: func(current, total int64) {
+			progress = current
+		},
+	}
+
+	data := "archive data"
+	result, err := uploader.Upload(context.Background(),
+		strings.NewReader(data), int64(len(data)), &Opts{Slug: "hashicorp/project"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Version != 5 {
+		t.Fatalf("expected %d to be %d", result.Version, 5)
+	}
+	if result.Size != int64(len(data)) {
+		t.Fatalf("expected %d to be %d", result.Size, len(data))
+	}
+
+	sum := sha256.Sum256([]byte(data))
+	if expected := hex.EncodeToString(sum[:]); result.Checksum != expected {
+		t.Fatalf("expected %q to be %q", result.Checksum, expected)
+	}
+
+	if string(body) != data {
+		t.Fatalf("expected %q to be %q", body, data)
+	}
+	if progress != int64(len(data)) {
+		t.Fatalf("expected progress %d to be %d", progress, len(data))
+	}
+}
+
+func TestUploader_Upload_retry(t *testing.T) {
+	var appRequests, putRequests int
+	var body []byte
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	mux.HandleFunc("/api/v1/vagrant/applications/hashicorp/project", func(w http.ResponseWriter, r *http.Request) {
+		appRequests++
+		if appRequests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		fmt.Fprint(w, `{"username": "hashicorp", "name": "project"}`)
+	})
+	mux.HandleFunc("/api/v1/vagrant/applications/hashicorp/project/versions", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"upload_path": "%s/upload", "version": 5}`, server.URL)
+	})
+	mux.HandleFunc("/upload", func(w http.ResponseWriter, r *http.Request) {
+		putRequests++
+
+		// Read part of the body, then fail the first attempt
+		if putRequests == 1 {
+			r.Body.Read(make([]byte, 3))
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		body, _ = ioutil.ReadAll(r.Body)
+	})
+
+	client, err := atlas.NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uploader := &Uploader{
+		Client: client,
+		Retry: RetryOpts{
+			Attempts: 3,
+			Backoff:  time.Millisecond,
+		},
+	}
+
+	data := "archive data"
+	result, err := uploader.Upload(context.Background(),
+		strings.NewReader(data), int64(len(data)), &Opts{Slug: "hashicorp/project"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Version != 5 {
+		t.Fatalf("expected %d to be %d", result.Version, 5)
+	}
+	if appRequests != 2 {
+		t.Fatalf("expected %d app requests, got %d", 2, appRequests)
+	}
+	if putRequests != 2 {
+		t.Fatalf("expected %d put requests, got %d", 2, putRequests)
+	}
+	if string(body) != data {
+		t.Fatalf("expected %q to be %q", body, data)
+	}
+
+	sum := sha256.Sum256([]byte(data))
+	if expected := hex.EncodeToString(sum[:]); result.Checksum != expected {
+		t.Fatalf("expected %q to be %q", result.Checksum, expected)
+	}
+}
+
+func TestUploader_Upload_retryCreate(t *testing.T) {
+	cases := []struct {
+		Status     int
+		RetryAfter string
+		Requests   int
+	}{
+		// The server may have created the version anyway
+		{http.StatusBadGateway, "", 1},
+		{http.StatusServiceUnavailable, "", 1},
+
+		// The server didn't create it
+		{http.StatusTooManyRequests, "", 2},
+		{http.StatusServiceUnavailable, "1", 2},
+	}
+
+	for _, tc := range cases {
+		var requests int
+		mux := http.NewServeMux()
+		server := httptest.NewServer(mux)
+
+		mux.HandleFunc("/api/v1/vagrant/applications/hashicorp/project", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"username": "hashicorp", "name": "project"}`)
+		})
+		mux.HandleFunc("/api/v1/vagrant/applications/hashicorp/project/versions", func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			if requests == 1 {
+				if tc.RetryAfter != "" {
+					w.Header().Set("Retry-After", tc.RetryAfter)
+				}
+				w.WriteHeader(tc.Status)
+				return
+			}
+
+			fmt.Fprintf(w, `{"upload_path": "%s/upload", "version": 5}`, server.URL)
+		})
+		mux.HandleFunc("/upload", func(w http.ResponseWriter, r *http.Request) {})
+
+		client, err := atlas.NewClient(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		uploader := &Uploader{
+			Client: client,
+			Retry: RetryOpts{
+				Attempts: 3,
+				Backoff:  time.Millisecond,
+			},
+		}
+
+		data := "archive data"
+		_, err = uploader.Upload(context.Background(),
+			strings.NewReader(data), int64(len(data)), &Opts{Slug: "hashicorp/project"})
+		server.Close()
+		if tc.Requests == 1 && err == nil {
+			t.Fatalf("%d: expected an error", tc.Status)
+		}
+		if tc.Requests > 1 && err != nil {
+			t.Fatalf("%d: err: %s", tc.Status, err)
+		}
+		if requests != tc.Requests {
+			t.Fatalf("%d %q: expected %d requests, got %d", tc.Status, tc.RetryAfter, tc.Requests, requests)
+		}
+	}
+}
+
+func TestUploader_Upload_noRetryOnAuth(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	client, err := atlas.NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uploader := &Uploader{Client: client, Retry: RetryOpts{Attempts: 3}}
+	_, err = uploader.Upload(context.Background(),
+		strings.NewReader(""), 0, &Opts{Slug: "hashicorp/project"})
+	if err == nil || !strings.Contains(err.Error(), atlas.ErrAuth.Error()) {
+		t.Fatalf("expected auth error, got %v", err)
+	}
+	if e, ok := err.(*Error); !ok || e.Err != atlas.ErrAuth {
+		t.Fatalf("expected an *Error with atlas.ErrAuth, got %#v", err)
+	}
+
+	if requests != 1 {
+		t.Fatalf("expected %d requests, got %d", 1, requests)
+	}
+}
+
+func TestUploader_Upload_confirmCreate(t *testing.T) {
+	var created bool
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	mux.HandleFunc("/api/v1/vagrant/applications/hashicorp/new", func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
+	mux.HandleFunc("/api/v1/vagrant/applications", func(w http.ResponseWriter, r *http.Request) {
+		created = true
+		fmt.Fprint(w, `{"username": "hashicorp", "name": "new"}`)
+	})
+	mux.HandleFunc("/api/v1/vagrant/applications/hashicorp/new/versions", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"upload_path": "%s/upload", "version": 1}`, server.URL)
+	})
+	mux.HandleFunc("/upload", func(w http.ResponseWriter, r *http.Request) {})
+
+	client, err := atlas.NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploader := &Uploader{Client: client}
+
+	// Declining fails with ErrNotFound and creates nothing
+	var asked string
+	opts := &Opts{
+		Slug: "hashicorp/new",
+		ConfirmCreate: func(slug string) (bool, error) {
+			asked = slug
+			return false, nil
+		},
+	}
+	_, err = uploader.Upload(context.Background(), strings.NewReader(""), 0, opts)
+	if e, ok := err.(*Error); !ok || e.Err != atlas.ErrNotFound {
+		t.Fatalf("expected an *Error with atlas.ErrNotFound, got %#v", err)
+	}
+	if asked != "hashicorp/new" {
+		t.Fatalf("expected %q to be %q", asked, "hashicorp/new")
+	}
+	if created {
+		t.Fatal("expected the application not to be created")
+	}
+
+	// Confirming creates the application
+	opts.ConfirmCreate = func(string) (bool, error) {
+		return true, nil
+	}
+	result, err := uploader.Upload(context.Background(), strings.NewReader(""), 0, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created || !result.Created {
+		t.Fatalf("expected the application to be created, got %#v", result)
+	}
+}
+
+func TestUploader_Upload_canceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	server, client := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		// Interrupt the upload while it is in flight
+		cancel()
+		ioutil.ReadAll(r.Body)
+	})
+	defer server.Close()
+
+	uploader := &Uploader{Client: client, Retry: RetryOpts{Attempts: 3}}
+
+	data := "archive data"
+	_, err := uploader.Upload(ctx,
+		strings.NewReader(data), int64(len(data)), &Opts{Slug: "hashicorp/project"})
+	if err == nil {
+		t.Fatal("expected the upload to fail")
+	}
+	if ctx.Err() == nil {
+		t.Fatal("expected the context to be canceled")
+	}
+}
+
+func TestUploader_Upload_contentHash(t *testing.T) {
+	var body []byte
+	server, client := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+	})
+	defer server.Close()
+
+	uploader := &Uploader{Client: client}
+	data := "archive data"
+	result, err := uploader.Upload(context.Background(),
+		strings.NewReader(data), int64(len(data)), &Opts{
+			Slug:        "hashicorp/project",
+			ContentHash: "abc",
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != data {
+		t.Fatalf("expected %q to be %q", body, data)
+	}
+	if result.Metadata[ContentHashKey] != "abc" {
+		t.Fatalf("bad metadata: %#v", result.Metadata)
+	}
+}
+
+func TestUploader_Upload_checksums(t *testing.T) {
+	var header http.Header
+	server, client := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		ioutil.ReadAll(r.Body)
+	})
+	defer server.Close()
+
+	data := "archive data"
+	sha := sha256.Sum256([]byte(data))
+	md := md5.Sum([]byte(data))
+
+	uploader := &Uploader{Client: client}
+	result, err := uploader.Upload(context.Background(),
+		strings.NewReader(data), int64(len(data)), &Opts{
+			Slug:          "hashicorp/project",
+			MD5:           hex.EncodeToString(md[:]),
+			DigestHeaders: true,
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Metadata[SHA256Key] != hex.EncodeToString(sha[:]) {
+		t.Fatalf("bad metadata: %#v", result.Metadata)
+	}
+	if result.Metadata[MD5Key] != hex.EncodeToString(md[:]) {
+		t.Fatalf("bad metadata: %#v", result.Metadata)
+	}
+
+	shaB64 := base64.StdEncoding.EncodeToString(sha[:])
+	mdB64 := base64.StdEncoding.EncodeToString(md[:])
+	if expected := "SHA-256=" + shaB64 + ",MD5=" + mdB64; header.Get("Digest") != expected {
+		t.Fatalf("expected %q to be %q", header.Get("Digest"), expected)
+	}
+	if header.Get("Content-MD5") != mdB64 {
+		t.Fatalf("expected %q to be %q", header.Get("Content-MD5"), mdB64)
+	}
+}
+
+func TestUploader_Upload_checksumMismatch(t *testing.T) {
+	server, client := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+	})
+	defer server.Close()
+
+	data := "archive data"
+	uploader := &Uploader{Client: client}
+	_, err := uploader.Upload(context.Background(),
+		strings.NewReader(data), int64(len(data)), &Opts{
+			Slug:   "hashicorp/project",
+			SHA256: strings.Repeat("0", 64),
+		})
+	if err == nil || !strings.Contains(err.Error(), "doesn't match") {
+		t.Fatalf("expected a checksum error, got %v", err)
+	}
+}
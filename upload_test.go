@@ -1,6 +1,9 @@
 package main
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 
@@ -54,3 +57,51 @@ func TestAtlasClient_token(t *testing.T) {
 		t.Fatalf("expected %q to be %q", client.Token, token)
 	}
 }
+
+func TestAtlasClient_credentialsFile(t *testing.T) {
+	defer os.Setenv(CredentialsFileEnvVar, os.Getenv(CredentialsFileEnvVar))
+	defer os.Setenv("ATLAS_TOKEN", os.Getenv("ATLAS_TOKEN"))
+	os.Setenv("ATLAS_TOKEN", "")
+
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv(CredentialsFileEnvVar, filepath.Join(dir, "credentials.json"))
+
+	url := "https://atlas.company.com"
+	client, err := atlasClient(&UploadOpts{URL: url})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := saveToken(client.URL, "saved"); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err = atlasClient(&UploadOpts{URL: url + "/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.Token != "saved" {
+		t.Fatalf("expected the saved token, got %q", client.Token)
+	}
+
+	// The flag and ATLAS_TOKEN take precedence
+	client, err = atlasClient(&UploadOpts{URL: url, Token: "flag"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.Token != "flag" {
+		t.Fatalf("expected the -token, got %q", client.Token)
+	}
+
+	os.Setenv("ATLAS_TOKEN", "env")
+	client, err = atlasClient(&UploadOpts{URL: url})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.Token != "env" {
+		t.Fatalf("expected ATLAS_TOKEN, got %q", client.Token)
+	}
+}
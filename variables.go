@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+
+	"github.com/hashicorp/atlas-go/v1"
+	"github.com/hashicorp/hcl"
+)
+
+// loadVarFile loads the Terraform variables from the given tfvars file.
+// Strings, numbers and booleans become string variables; lists and maps
+// become HCL variables.
+func loadVarFile(path string) ([]atlas.TFVar, error) {
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading variables: %s", err)
+	}
+
+	var values map[string]interface{}
+	if err := hcl.Decode(&values, string(d)); err != nil {
+		return nil, fmt.Errorf("error parsing variables %s: %s", path, err)
+	}
+
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	vars := make([]atlas.TFVar, 0, len(keys))
+	for _, k := range keys {
+		switch v := values[k].(type) {
+		case string, bool, int, int64, float64:
+			vars = append(vars, atlas.TFVar{Key: k, Value: fmt.Sprint(v)})
+		default:
+			var buf bytes.Buffer
+			if err := encodeHCL(&buf, v); err != nil {
+				return nil, fmt.Errorf("error parsing variables %s: %s: %s", path, k, err)
+			}
+			vars = append(vars, atlas.TFVar{Key: k, Value: buf.String(), IsHCL: true})
+		}
+	}
+
+	return vars, nil
+}
+
+// encodeHCL writes the value, as decoded by hcl.Decode, as HCL.
+func encodeHCL(buf *bytes.Buffer, v interface{}) error {
+	switch v := v.(type) {
+	case string:
+		buf.WriteString(strconv.Quote(v))
+	case bool, int, int64, float64:
+		fmt.Fprint(buf, v)
+	case []interface{}:
+		buf.WriteString("[")
+		for i, item := range v {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			if err := encodeHCL(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteString("]")
+	case []map[string]interface{}:
+		// HCL decodes every map as a list of maps, one for each time the
+		// key was given, which together make up the map
+		merged := make(map[string]interface{})
+		for _, m := range v {
+			for k, item := range m {
+				merged[k] = item
+			}
+		}
+		return encodeHCL(buf, merged)
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		buf.WriteString("{")
+		for i, k := range keys {
+			if i > 0 {
+				buf.WriteString(",")
+			}
+			fmt.Fprintf(buf, " %s = ", strconv.Quote(k))
+			if err := encodeHCL(buf, v[k]); err != nil {
+				return err
+			}
+		}
+		buf.WriteString(" }")
+	default:
+		return fmt.Errorf("unsupported value of type %T", v)
+	}
+
+	return nil
+}
+
+// uniqueVariables returns the variables with only the last one of every
+// key, in the order in which the keys were first given.
+func uniqueVariables(vars []atlas.TFVar) []atlas.TFVar {
+	index := make(map[string]int, len(vars))
+	var result []atlas.TFVar
+	for _, v := range vars {
+		if i, ok := index[v.Key]; ok {
+			result[i] = v
+			continue
+		}
+
+		index[v.Key] = len(result)
+		result = append(result, v)
+	}
+
+	return result
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/atlas-go/v1"
+	"github.com/hashicorp/hcl"
+)
+
+func TestLoadVarFile(t *testing.T) {
+	vars, err := loadVarFile(filepath.Join(testFixture("terraform-dir"), "terraform.tfvars"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := []atlas.TFVar{
+		{Key: "count", Value: "3"},
+		{Key: "region", Value: "us-east-1"},
+		{Key: "tags", Value: `{ "env" = "prod", "team" = "ops" }`, IsHCL: true},
+		{Key: "zones", Value: `["us-east-1a", "us-east-1b"]`, IsHCL: true},
+	}
+	if !reflect.DeepEqual(vars, expected) {
+		t.Fatalf("expected %#v to be %#v", vars, expected)
+	}
+
+	// The HCL variables must parse back to the same values
+	for _, v := range vars[2:] {
+		var parsed map[string]interface{}
+		if err := hcl.Decode(&parsed, v.Key+" = "+v.Value); err != nil {
+			t.Fatalf("%s: err: %s", v.Key, err)
+		}
+	}
+}
+
+func TestEncodeHCL(t *testing.T) {
+	cases := []struct {
+		Value    interface{}
+		Expected string
+	}{
+		{"a \"b\"", `"a \"b\""`},
+		{[]interface{}{1, true}, `[1, true]`},
+		{[]map[string]interface{}{{"a": "1"}, {"b": []interface{}{"2"}}}, `{ "a" = "1", "b" = ["2"] }`},
+	}
+
+	for _, tc := range cases {
+		var buf bytes.Buffer
+		if err := encodeHCL(&buf, tc.Value); err != nil {
+			t.Fatalf("%#v: err: %s", tc.Value, err)
+		}
+		if buf.String() != tc.Expected {
+			t.Fatalf("expected %q to be %q", buf.String(), tc.Expected)
+		}
+	}
+}
+
+func TestFlagVariablesVar(t *testing.T) {
+	var vars []atlas.TFVar
+	str := &FlagVariablesVar{Variables: &vars}
+	hclVar := &FlagVariablesVar{Variables: &vars, HCL: true}
+
+	if err := str.Set("region=us-east-1"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := hclVar.Set(`zones=["a", "b"]`); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := hclVar.Set(`zones=["a"`); err == nil {
+		t.Fatal("expected error for invalid HCL")
+	}
+	if err := str.Set("region"); err == nil {
+		t.Fatal("expected error for missing '='")
+	}
+
+	expected := []atlas.TFVar{
+		{Key: "region", Value: "us-east-1"},
+		{Key: "zones", Value: `["a", "b"]`, IsHCL: true},
+	}
+	if !reflect.DeepEqual(vars, expected) {
+		t.Fatalf("expected %#v to be %#v", vars, expected)
+	}
+}
+
+func TestUniqueVariables(t *testing.T) {
+	vars := uniqueVariables([]atlas.TFVar{
+		{Key: "a", Value: "1"},
+		{Key: "b", Value: "2"},
+		{Key: "a", Value: "3"},
+	})
+
+	expected := []atlas.TFVar{
+		{Key: "a", Value: "3"},
+		{Key: "b", Value: "2"},
+	}
+	if !reflect.DeepEqual(vars, expected) {
+		t.Fatalf("expected %#v to be %#v", vars, expected)
+	}
+}